@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/handlers/update"
+	"github.com/lildude/strautomagically/internal/rules"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+// command is a CLI subcommand.
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"cache":          {"cache keys [prefix]|get <key>|delete <key>...: list, show or delete keys in the cache", cacheCmd},
+	"deadletters":    {"deadletters list|show <id>|replay <id>: list, inspect or replay webhook events that failed on every attempt", deadLettersCmd},
+	"encrypt-tokens": {"encrypt-tokens: encrypt stored tokens with the first key in TOKEN_ENCRYPTION_KEYS", encryptTokensCmd},
+	"explain":        {"explain [-athlete id] <activity-id|activity.json>: show the changes the rules would make to an activity without updating it", explainCmd},
+	"subscription":   {"subscription list|ensure|delete <id>: manage the webhook subscription for STRAVA_CALLBACK_URI", subscriptionCmd},
+	"test-rules":     {"test-rules [-rules rules.yaml] [-dir ruletests] [-update]: check the rules make the expected changes to the test activities", testRulesCmd},
+}
+
+// runCommand runs the named CLI command and returns the exit code.
+func runCommand(name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\nUsage: strautomagically [command]\n\nRuns the server if no command is given.\n\nCommands:\n", name)
+		names := make([]string, 0, len(commands))
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintf(os.Stderr, "  %s\n", commands[n].usage)
+		}
+		return 2
+	}
+
+	if err := cmd.run(context.Background(), args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// explainCmd prints the changes the rules would make to an activity fetched from
+// Strava by ID, or read from a JSON file like those in the update handler testdata.
+func explainCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	athleteID := fs.Int64("athlete", 0, "ID of the athlete the activity belongs to, required to fetch the activity from Strava")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: strautomagically explain [-athlete id] <activity-id|activity.json>")
+	}
+	if err := update.LoadRules(rulesFile()); err != nil {
+		return err
+	}
+
+	var activity *strava.Activity
+	if id, err := strconv.ParseInt(fs.Arg(0), 10, 64); err == nil {
+		if *athleteID == 0 {
+			return errors.New("-athlete is required to fetch an activity")
+		}
+		activity, err = update.FetchActivity(ctx, *athleteID, id)
+		if err != nil {
+			return err
+		}
+	} else {
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &activity); err != nil {
+			return fmt.Errorf("parsing activity: %w", err)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(update.Explain(ctx, activity))
+}
+
+// testRulesCmd runs the rules against the test activities and reports any that don't
+// get the update in their golden file, or regenerates the golden files with -update.
+func testRulesCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("test-rules", flag.ContinueOnError)
+	rulesPath := fs.String("rules", rulesFile(), "rules file to test")
+	dir := fs.String("dir", "ruletests", "directory holding the test activities and golden files")
+	regenerate := fs.Bool("update", false, "write the changes the rules make to the golden files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rs, err := rules.Load(*rulesPath)
+	if err != nil {
+		return err
+	}
+
+	results, err := rs.RunTests(ctx, *dir, *regenerate)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no tests found in %s", *dir)
+	}
+
+	failed := 0
+	for _, res := range results {
+		switch {
+		case res.Err != nil:
+			failed++
+			fmt.Printf("FAIL %s: %v\n", res.Name, res.Err)
+		case res.Diff != "":
+			failed++
+			fmt.Printf("FAIL %s:\n%s", res.Name, res.Diff)
+		case res.Updated:
+			fmt.Printf("updated %s\n", res.Name)
+		default:
+			fmt.Printf("ok   %s\n", res.Name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tests failed", failed, len(results))
+	}
+	return nil
+}
+
+// deadLettersCmd lists, shows or replays the webhook events that failed on every attempt.
+// Replayed events are processed straight away rather than queued.
+func deadLettersCmd(ctx context.Context, args []string) error {
+	const usage = "usage: strautomagically deadletters list|show <id>|replay <id>"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		dls, err := update.ListDeadLetters(ctx)
+		if err != nil {
+			return err
+		}
+		for _, dl := range dls {
+			fmt.Printf("%s\tfailed %s after %d attempts: %s\n", dl.ID, dl.FailedAt.Format(time.RFC3339), dl.Job.Attempts, dl.Error)
+		}
+		return nil
+	case args[0] == "show" && len(args) == 2:
+		dl, err := update.GetDeadLetter(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		return enc.Encode(dl)
+	case args[0] == "replay" && len(args) == 2:
+		// The event is processed straight away if there's no queue
+		if err := update.LoadRules(rulesFile()); err != nil {
+			return err
+		}
+		if err := update.ReplayDeadLetter(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("replayed %s\n", args[1])
+		return nil
+	default:
+		return errors.New(usage)
+	}
+}
+
+// cacheCmd lists, shows or deletes keys in the cache configured by DATABASE_URL or REDIS_URL.
+func cacheCmd(ctx context.Context, args []string) error {
+	const usage = "usage: strautomagically cache keys [prefix]|get <key>|delete <key>..."
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	c, err := cache.Open(ctx, cache.URL())
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "keys" && len(args) <= 2:
+		prefix := ""
+		if len(args) == 2 {
+			prefix = args[1]
+		}
+		keys, err := c.Keys(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			fmt.Println(k)
+		}
+		return nil
+	case args[0] == "get" && len(args) == 2:
+		exists, err := c.Exists(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("key %q not found", args[1])
+		}
+		v, err := c.Get(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(v)
+		return nil
+	case args[0] == "delete" && len(args) >= 2:
+		return c.Delete(ctx, args[1:]...)
+	default:
+		return errors.New(usage)
+	}
+}
+
+// encryptTokensCmd encrypts tokens stored before encryption was enabled, or with a key
+// that's being rotated out, with the first key in TOKEN_ENCRYPTION_KEYS.
+func encryptTokensCmd(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: strautomagically encrypt-tokens")
+	}
+
+	c, err := cache.Open(ctx, cache.URL())
+	if err != nil {
+		return err
+	}
+	n, err := strava.EncryptTokens(ctx, c)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("encrypted %d tokens\n", n)
+	return nil
+}
+
+// subscriptionCmd lists, creates or deletes the webhook subscription. ensure subscribes to
+// events sent to STRAVA_CALLBACK_URI, replacing a subscription for another URL, and needs
+// the app to be running at that URL, using the same Redis or SQLite cache, to answer
+// Strava's verification request.
+func subscriptionCmd(ctx context.Context, args []string) error {
+	const usage = "usage: strautomagically subscription list|ensure|delete <id>"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		subs, err := strava.ListSubscriptions(ctx)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			fmt.Printf("%d\t%s\tcreated %s\n", sub.ID, sub.CallbackURL, sub.CreatedAt.Format(time.RFC3339))
+		}
+		return nil
+	case args[0] == "ensure" && len(args) == 1:
+		c, err := cache.Open(ctx, cache.URL())
+		if err != nil {
+			return err
+		}
+		sub, created, err := strava.EnsureSubscribed(ctx, c, os.Getenv("STRAVA_CALLBACK_URI"))
+		if err != nil {
+			return err
+		}
+		if created {
+			fmt.Printf("created subscription %d for %s\n", sub.ID, sub.CallbackURL)
+		} else {
+			fmt.Printf("already subscribed with subscription %d for %s\n", sub.ID, sub.CallbackURL)
+		}
+		return nil
+	case args[0] == "delete" && len(args) == 2:
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid subscription id %q", args[1])
+		}
+		c, err := cache.Open(ctx, cache.URL())
+		if err != nil {
+			return err
+		}
+		if err := strava.DeleteSubscription(ctx, c, id); err != nil {
+			return err
+		}
+		fmt.Printf("deleted subscription %d\n", id)
+		return nil
+	default:
+		return errors.New(usage)
+	}
+}
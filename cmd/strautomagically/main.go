@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	// Autoloads .env file to supply environment variables.
@@ -12,6 +15,8 @@ import (
 	"github.com/lildude/strautomagically/internal/handlers/auth"
 	"github.com/lildude/strautomagically/internal/handlers/callback"
 	"github.com/lildude/strautomagically/internal/handlers/update"
+	"github.com/lildude/strautomagically/internal/queue"
+	"github.com/lildude/strautomagically/internal/strava"
 )
 
 var Version = "dev"
@@ -22,10 +27,44 @@ func main() {
 		port = ":" + val
 	}
 
+	// Run a CLI command rather than the server if one is given
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
+	if err := update.LoadRules(rulesFile()); err != nil {
+		slog.Error("unable to load rules", "error", err)
+		os.Exit(1)
+	}
+
+	// Process webhook events in the background if a queue is configured, otherwise
+	// they're processed before responding to the webhook
+	if queueURL := os.Getenv("QUEUE_URL"); queueURL != "" {
+		jobs, err := queue.Open(context.Background(), queueURL)
+		if err != nil {
+			slog.Error("unable to open queue", "error", err)
+			os.Exit(1)
+		}
+		update.SetQueue(jobs)
+
+		workers, _ := strconv.Atoi(os.Getenv("QUEUE_WORKERS"))
+		if workers <= 0 {
+			workers = 2
+		}
+		pool := &queue.Pool{Queue: jobs, Handler: update.ProcessJob, Workers: workers, Failed: update.StoreDeadLetter}
+		go pool.Run(context.Background())
+	}
+
+	// Show how much of Strava's rate limit has been used at /debug/vars
+	expvar.Publish("strava_rate_limit", expvar.Func(func() any { return strava.RateLimit() }))
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/start", indexHandler)
 	mux.HandleFunc("/auth", auth.AuthHandler)
 	mux.HandleFunc("/webhook", webhookHandler)
+	mux.HandleFunc("/explain", update.ExplainHandler)
+	mux.HandleFunc("/deadletters", update.DeadLettersHandler)
+	mux.HandleFunc("/debug/vars", update.VarsHandler)
 
 	srv := &http.Server{
 		Addr:              port,
@@ -39,6 +78,14 @@ func main() {
 	}
 }
 
+// rulesFile returns the path of the rules file from RULES_FILE, defaulting to rules.yaml.
+func rulesFile() string {
+	if val, ok := os.LookupEnv("RULES_FILE"); ok {
+		return val
+	}
+	return "rules.yaml"
+}
+
 func indexHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Strautomagically-Version", Version)
 	if _, err := w.Write([]byte("Strautomagically")); err != nil {
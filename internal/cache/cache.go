@@ -1,4 +1,4 @@
-// Package cache implements a Redis cache.
+// Package cache implements the key-value store used to hold tokens and state.
 package cache
 
 import (
@@ -6,6 +6,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	redis "github.com/go-redis/redis/v8"
 )
@@ -15,6 +20,62 @@ type Cache interface {
 	Set(ctx context.Context, key string, value any) error
 	GetJSON(ctx context.Context, key string, value any) error
 	SetJSON(ctx context.Context, key string, value any) error
+	Delete(ctx context.Context, keys ...string) error
+	SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error)
+	SetEX(ctx context.Context, key string, value any, ttl time.Duration) error
+	Exists(ctx context.Context, key string) (bool, error)
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// URL returns the URL of the cache from DATABASE_URL, falling back to REDIS_URL.
+func URL() string {
+	if val, ok := os.LookupEnv("DATABASE_URL"); ok && val != "" {
+		return val
+	}
+	return os.Getenv("REDIS_URL")
+}
+
+var (
+	openMu sync.Mutex
+	opened = map[string]Cache{}
+)
+
+// Open returns the cache for the URL:
+//
+//   - "redis://" and "rediss://" URLs are a Redis cache.
+//   - "sqlite://" URLs, like sqlite:///home/data/strautomagically.db, are a SQLite database file.
+//   - "file://" URLs, like file:///home/data/cache.json, are a JSON file, which suits hobby deployments.
+//   - "memory" is an in-process cache, which loses everything when the process exits.
+//
+// Handlers open the cache for every request so all but Redis caches are opened once and shared.
+func Open(ctx context.Context, url string) (Cache, error) {
+	if strings.HasPrefix(url, "redis://") || strings.HasPrefix(url, "rediss://") {
+		return NewRedisCache(ctx, url)
+	}
+
+	openMu.Lock()
+	defer openMu.Unlock()
+	if c, ok := opened[url]; ok {
+		return c, nil
+	}
+
+	var c Cache
+	var err error
+	switch {
+	case url == "memory":
+		c = NewMemoryCache()
+	case strings.HasPrefix(url, "sqlite://"):
+		c, err = NewSQLiteCache(ctx, strings.TrimPrefix(url, "sqlite://"))
+	case strings.HasPrefix(url, "file://"):
+		c, err = NewFileCache(strings.TrimPrefix(url, "file://"))
+	default:
+		return nil, fmt.Errorf("unsupported cache URL %q: must be memory or a redis://, sqlite:// or file:// URL", url)
+	}
+	if err != nil {
+		return nil, err
+	}
+	opened[url] = c
+	return c, nil
 }
 
 type RedisCache struct {
@@ -52,7 +113,17 @@ func (rc *RedisCache) Get(ctx context.Context, key string) (any, error) {
 
 // GetJSON retrieves a JSON string and unmarshals it into the given value.
 func (rc *RedisCache) GetJSON(ctx context.Context, key string, value any) error {
-	v, err := rc.Get(ctx, key)
+	return getJSON(ctx, rc, key, value)
+}
+
+// SetJSON stores a struct as a JSON string.
+func (rc *RedisCache) SetJSON(ctx context.Context, key string, value any) error {
+	return setJSON(ctx, rc, key, value)
+}
+
+// getJSON retrieves a JSON string from the cache and unmarshals it into the given value.
+func getJSON(ctx context.Context, c Cache, key string, value any) error {
+	v, err := c.Get(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -68,11 +139,54 @@ func (rc *RedisCache) GetJSON(ctx context.Context, key string, value any) error
 	return nil
 }
 
-// SetJSON stores a struct as a JSON string.
-func (rc *RedisCache) SetJSON(ctx context.Context, key string, value any) error {
+// setJSON stores a struct in the cache as a JSON string.
+func setJSON(ctx context.Context, c Cache, key string, value any) error {
 	t, err := json.Marshal(value)
 	if err != nil {
 		return fmt.Errorf("marshaling JSON for cache key %q: %w", key, err)
 	}
-	return rc.Set(ctx, key, string(t))
+	return c.Set(ctx, key, string(t))
+}
+
+// Delete removes the keys from the cache. Keys that don't exist are ignored.
+func (rc *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return rc.conn.Del(ctx, keys...).Err()
+}
+
+// SetNX atomically stores a value in the cache if the key doesn't already exist and
+// reports whether it was stored. The key expires after ttl, or never if ttl is 0.
+func (rc *RedisCache) SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	return rc.conn.SetNX(ctx, key, value, ttl).Result()
+}
+
+// SetEX stores a value in the cache that expires after ttl, or never if ttl is 0.
+func (rc *RedisCache) SetEX(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return rc.conn.Set(ctx, key, value, ttl).Err()
+}
+
+// Exists reports whether the key is in the cache.
+func (rc *RedisCache) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := rc.conn.Exists(ctx, key).Result()
+	return n == 1, err
+}
+
+// Keys returns the keys in the cache that start with the prefix, sorted.
+func (rc *RedisCache) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	iter := rc.conn.Scan(ctx, 0, globEscaper.Replace(prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	// SCAN can return a key more than once
+	slices.Sort(keys)
+	return slices.Compact(keys), nil
 }
+
+// globEscaper escapes the characters Redis treats as special in patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
@@ -2,8 +2,11 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	miniredis "github.com/alicebob/miniredis/v2"
 )
@@ -71,3 +74,104 @@ func TestSetGetJSON(t *testing.T) {
 		t.Errorf("expected {\"Name\":\"jsontest\",\"Age\":10}, got %v", test2)
 	}
 }
+
+func TestDelete(t *testing.T) {
+	r := miniredis.RunT(t)
+	defer r.Close()
+	ctx := context.Background()
+	cache, err := NewRedisCache(ctx, "redis://"+r.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"one", "two", "three"} {
+		if err := cache.Set(ctx, k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cache.Delete(ctx, "one", "two", "missing"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for k, want := range map[string]string{"one": "", "two": "", "three": "three"} {
+		if value, _ := cache.Get(ctx, k); value != want {
+			t.Errorf("expected %s to be %q, got %q", k, want, value)
+		}
+	}
+}
+
+func TestSetNX(t *testing.T) {
+	r := miniredis.RunT(t)
+	defer r.Close()
+	ctx := context.Background()
+	cache, err := NewRedisCache(ctx, "redis://"+r.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, false} {
+		ok, err := cache.SetNX(ctx, "event", "1", time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok != want {
+			t.Errorf("attempt %d: expected %v, got %v", i+1, want, ok)
+		}
+	}
+
+	r.FastForward(time.Minute)
+	if ok, _ := cache.SetNX(ctx, "event", "1", time.Minute); !ok {
+		t.Error("expected key to be stored after it expired")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	r := miniredis.RunT(t)
+	defer r.Close()
+	ctx := context.Background()
+	dbURL := "sqlite://" + filepath.Join(t.TempDir(), "test.db")
+
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{"redis://" + r.Addr(), "*cache.RedisCache", false},
+		{dbURL, "*cache.SQLiteCache", false},
+		{"file://" + filepath.Join(t.TempDir(), "cache.json"), "*cache.FileCache", false},
+		{"memory", "*cache.MemoryCache", false},
+		{"sqlite://" + t.TempDir(), "", true},
+		{"memcached://localhost", "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			c, err := Open(ctx, tc.url)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if got := fmt.Sprintf("%T", c); !tc.wantErr && got != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+
+	// Caches other than Redis are shared
+	for _, url := range []string{dbURL, "memory"} {
+		c1, _ := Open(ctx, url)
+		c2, _ := Open(ctx, url)
+		if c1 != c2 {
+			t.Errorf("expected the same cache to be returned for %s", url)
+		}
+	}
+}
+
+func TestURL(t *testing.T) {
+	t.Setenv("REDIS_URL", "redis://localhost:6379")
+	t.Setenv("DATABASE_URL", "")
+	if got := URL(); got != "redis://localhost:6379" {
+		t.Errorf("expected REDIS_URL, got %s", got)
+	}
+	t.Setenv("DATABASE_URL", "sqlite://data.db")
+	if got := URL(); got != "sqlite://data.db" {
+		t.Errorf("expected DATABASE_URL, got %s", got)
+	}
+}
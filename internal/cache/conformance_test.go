@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+)
+
+// backends opens a new, empty cache for each implementation along with a func to move
+// its clock forward so key expiry can be tested.
+var backends = []struct {
+	name string
+	open func(t *testing.T) (Cache, func(time.Duration))
+}{
+	{"redis", func(t *testing.T) (Cache, func(time.Duration)) {
+		r := miniredis.RunT(t)
+		c, err := NewRedisCache(context.Background(), "redis://"+r.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, r.FastForward
+	}},
+	{"sqlite", func(t *testing.T) (Cache, func(time.Duration)) {
+		c, err := NewSQLiteCache(context.Background(), filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c, fastForward(&c.now)
+	}},
+	{"memory", func(t *testing.T) (Cache, func(time.Duration)) {
+		c := NewMemoryCache()
+		return c, fastForward(&c.now)
+	}},
+	{"file", func(t *testing.T) (Cache, func(time.Duration)) {
+		c, err := NewFileCache(filepath.Join(t.TempDir(), "cache.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, fastForward(&c.mem.now)
+	}},
+}
+
+// fastForward replaces the clock with one that's moved forward by the returned func.
+func fastForward(now *func() time.Time) func(time.Duration) {
+	t := time.Now()
+	*now = func() time.Time { return t }
+	return func(d time.Duration) { t = t.Add(d) }
+}
+
+// TestConformance checks every Cache implementation behaves the same way.
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, c Cache, ff func(time.Duration))
+	}{
+		{"set and get", testSetGet},
+		{"set and get JSON", testSetGetJSON},
+		{"delete", testDelete},
+		{"set if not exists", testSetNX},
+		{"set if not exists concurrently", testSetNXConcurrent},
+		{"set with expiry", testSetEX},
+		{"exists", testExists},
+		{"keys", testKeys},
+	}
+
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			for _, tc := range tests {
+				t.Run(tc.name, func(t *testing.T) {
+					c, ff := b.open(t)
+					tc.test(t, c, ff)
+				})
+			}
+		})
+	}
+}
+
+func testSetGet(t *testing.T, c Cache, _ func(time.Duration)) {
+	ctx := context.Background()
+	if value, err := c.Get(ctx, "test"); err != nil || value != "" {
+		t.Errorf("expected missing key to be empty, got %q, %v", value, err)
+	}
+	for _, v := range []string{"test", "updated"} {
+		if err := c.Set(ctx, "test", v); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := c.Get(ctx, "test"); err != nil || value != v {
+			t.Errorf("expected %s, got %q, %v", v, value, err)
+		}
+	}
+	if err := c.Set(ctx, "number", 42); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := c.Get(ctx, "number"); value != "42" {
+		t.Errorf("expected numbers to be stored as strings, got %q", value)
+	}
+}
+
+func testSetGetJSON(t *testing.T, c Cache, _ func(time.Duration)) {
+	ctx := context.Background()
+	type Test struct {
+		Name string
+		Age  int
+	}
+	if err := c.SetJSON(ctx, "jsontest", Test{Name: "jsontest", Age: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if js, _ := c.Get(ctx, "jsontest"); js != `{"Name":"jsontest","Age":10}` {
+		t.Errorf("expected `{\"Name\":\"jsontest\",\"Age\":10}`, got %s", js)
+	}
+
+	var got Test
+	if err := c.GetJSON(ctx, "jsontest", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "jsontest" || got.Age != 10 {
+		t.Errorf("expected {jsontest 10}, got %v", got)
+	}
+	if err := c.GetJSON(ctx, "missing", &got); err == nil {
+		t.Error("expected error getting JSON for a missing key")
+	}
+}
+
+func testDelete(t *testing.T, c Cache, _ func(time.Duration)) {
+	ctx := context.Background()
+	for _, k := range []string{"one", "two", "three"} {
+		if err := c.Set(ctx, k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Delete(ctx, "one", "two", "missing"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := c.Delete(ctx); err != nil {
+		t.Errorf("unexpected error deleting no keys: %v", err)
+	}
+	for k, want := range map[string]string{"one": "", "two": "", "three": "three"} {
+		if value, _ := c.Get(ctx, k); value != want {
+			t.Errorf("expected %s to be %q, got %q", k, want, value)
+		}
+	}
+}
+
+func testSetNX(t *testing.T, c Cache, ff func(time.Duration)) {
+	ctx := context.Background()
+	for i, want := range []bool{true, false} {
+		ok, err := c.SetNX(ctx, "event", "1", time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok != want {
+			t.Errorf("attempt %d: expected %v, got %v", i+1, want, ok)
+		}
+	}
+
+	ff(time.Minute)
+	if value, _ := c.Get(ctx, "event"); value != "" {
+		t.Errorf("expected expired key to be empty, got %q", value)
+	}
+	if ok, _ := c.SetNX(ctx, "event", "1", time.Minute); !ok {
+		t.Error("expected key to be stored after it expired")
+	}
+
+	// Set removes the TTL
+	if err := c.Set(ctx, "event", "2"); err != nil {
+		t.Fatal(err)
+	}
+	ff(time.Hour)
+	if value, _ := c.Get(ctx, "event"); value != "2" {
+		t.Errorf("expected key without a TTL to be kept, got %q", value)
+	}
+	if ok, _ := c.SetNX(ctx, "event", "3", 0); ok {
+		t.Error("expected existing key without a TTL not to be replaced")
+	}
+}
+
+func testSetNXConcurrent(t *testing.T, c Cache, _ func(time.Duration)) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	stored := 0
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			ok, err := c.SetNX(ctx, "event", "1", time.Minute)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if ok {
+				mu.Lock()
+				stored++
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	if stored != 1 {
+		t.Errorf("expected the key to be stored once, got %d", stored)
+	}
+}
+
+func testSetEX(t *testing.T, c Cache, ff func(time.Duration)) {
+	ctx := context.Background()
+	if err := c.SetEX(ctx, "state", "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetEX(ctx, "forever", "1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := c.Get(ctx, "state"); value != "1" {
+		t.Errorf("expected 1, got %q", value)
+	}
+
+	// Setting the key again replaces the TTL
+	ff(30 * time.Second)
+	if err := c.SetEX(ctx, "state", "2", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	ff(45 * time.Second)
+	if value, _ := c.Get(ctx, "state"); value != "2" {
+		t.Errorf("expected TTL to be replaced, got %q", value)
+	}
+
+	ff(time.Minute)
+	if value, _ := c.Get(ctx, "state"); value != "" {
+		t.Errorf("expected expired key to be empty, got %q", value)
+	}
+	if value, _ := c.Get(ctx, "forever"); value != "1" {
+		t.Errorf("expected key without a TTL to be kept, got %q", value)
+	}
+}
+
+func testExists(t *testing.T, c Cache, ff func(time.Duration)) {
+	ctx := context.Background()
+	if err := c.Set(ctx, "key", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetEX(ctx, "expiring", "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{"key": true, "expiring": true, "missing": false} {
+		if got, err := c.Exists(ctx, key); err != nil || got != want {
+			t.Errorf("expected %s to exist %v, got %v, %v", key, want, got, err)
+		}
+	}
+
+	ff(time.Minute)
+	if got, _ := c.Exists(ctx, "expiring"); got {
+		t.Error("expected expired key not to exist")
+	}
+}
+
+func testKeys(t *testing.T, c Cache, ff func(time.Duration)) {
+	ctx := context.Background()
+	for _, k := range []string{"token:2", "token:1", "Token:3", "tokens", "token*:4", "other"} {
+		if err := c.Set(ctx, k, "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.SetEX(ctx, "token:5", "1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"token:", []string{"token:1", "token:2", "token:5"}},
+		{"token*", []string{"token*:4"}},
+		{"missing", []string{}},
+		{"", []string{"Token:3", "other", "token*:4", "token:1", "token:2", "token:5", "tokens"}},
+	}
+	for _, tc := range tests {
+		got, err := c.Keys(ctx, tc.prefix)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("prefix %q: expected %v, got %v", tc.prefix, tc.want, got)
+		}
+	}
+
+	ff(time.Minute)
+	if got, _ := c.Keys(ctx, "token:"); !slices.Equal(got, []string{"token:1", "token:2"}) {
+		t.Errorf("expected expired keys not to be listed, got %v", got)
+	}
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileCache is a cache held in memory and saved to a JSON file after every change.
+// It's meant for hobby deployments with a single process and only a few athletes.
+type FileCache struct {
+	mem  *MemoryCache
+	path string
+	// saveMu stops concurrent changes writing the file at the same time.
+	saveMu sync.Mutex
+}
+
+// NewFileCache returns a cache saved to the JSON file at the path, loading the file if it exists.
+func NewFileCache(path string) (*FileCache, error) {
+	fc := &FileCache{mem: NewMemoryCache(), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fc, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading cache file: %w", err)
+	}
+	if err := json.Unmarshal(data, &fc.mem.entries); err != nil {
+		return nil, fmt.Errorf("parsing cache file %s: %w", path, err)
+	}
+	if fc.mem.entries == nil {
+		fc.mem.entries = map[string]entry{}
+	}
+	return fc, nil
+}
+
+// save writes the cache to the file, replacing it atomically so a crash can't leave it half written.
+func (fc *FileCache) save() error {
+	fc.saveMu.Lock()
+	defer fc.saveMu.Unlock()
+
+	fc.mem.mu.Lock()
+	data, err := json.MarshalIndent(fc.mem.entries, "", "  ")
+	fc.mem.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("marshaling cache file: %w", err)
+	}
+
+	// The file holds tokens so only the owner can read the temp file, which keeps its mode when renamed
+	tmp, err := os.CreateTemp(filepath.Dir(fc.path), filepath.Base(fc.path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), fc.path); err != nil {
+		return fmt.Errorf("writing cache file: %w", err)
+	}
+	return nil
+}
+
+// Set stores a value in the cache.
+func (fc *FileCache) Set(ctx context.Context, key string, value any) error {
+	if err := fc.mem.Set(ctx, key, value); err != nil {
+		return err
+	}
+	return fc.save()
+}
+
+// Get retrieves a value from the cache. Keys that don't exist have an empty value.
+func (fc *FileCache) Get(ctx context.Context, key string) (any, error) {
+	return fc.mem.Get(ctx, key)
+}
+
+// GetJSON retrieves a JSON string and unmarshals it into the given value.
+func (fc *FileCache) GetJSON(ctx context.Context, key string, value any) error {
+	return getJSON(ctx, fc, key, value)
+}
+
+// SetJSON stores a struct as a JSON string.
+func (fc *FileCache) SetJSON(ctx context.Context, key string, value any) error {
+	return setJSON(ctx, fc, key, value)
+}
+
+// Delete removes the keys from the cache. Keys that don't exist are ignored.
+func (fc *FileCache) Delete(ctx context.Context, keys ...string) error {
+	if err := fc.mem.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	return fc.save()
+}
+
+// SetNX atomically stores a value in the cache if the key doesn't already exist and
+// reports whether it was stored. The key expires after ttl, or never if ttl is 0.
+func (fc *FileCache) SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	ok, err := fc.mem.SetNX(ctx, key, value, ttl)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, fc.save()
+}
+
+// SetEX stores a value in the cache that expires after ttl, or never if ttl is 0.
+func (fc *FileCache) SetEX(ctx context.Context, key string, value any, ttl time.Duration) error {
+	if err := fc.mem.SetEX(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	return fc.save()
+}
+
+// Exists reports whether the key is in the cache.
+func (fc *FileCache) Exists(ctx context.Context, key string) (bool, error) {
+	return fc.mem.Exists(ctx, key)
+}
+
+// Keys returns the keys in the cache that start with the prefix, sorted.
+func (fc *FileCache) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return fc.mem.Keys(ctx, prefix)
+}
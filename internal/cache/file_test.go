@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCache(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	c, err := NewFileCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "token", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected cache file to be written: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("expected cache file to only be readable by its owner, got %v", mode)
+	}
+
+	// Reopening the file keeps the data
+	c, err = NewFileCache(path)
+	if err != nil {
+		t.Fatalf("unexpected error reopening cache file: %v", err)
+	}
+	if value, _ := c.Get(ctx, "token"); value != "secret" {
+		t.Errorf("expected data to be kept, got %q", value)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileCache(path); err == nil {
+		t.Error("expected error opening an invalid cache file")
+	}
+}
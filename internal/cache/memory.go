@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entry is a value held by the memory and file caches.
+type entry struct {
+	Value string `json:"value"`
+	// ExpiresAt is when the entry expires in Unix milliseconds, or 0 if it never does.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// MemoryCache is an in-process cache. Everything in it is lost when the process exits
+// so it's best suited to tests and development.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]entry
+	// now returns the current time, used to expire keys.
+	now func() time.Time
+}
+
+// NewMemoryCache returns an empty in-process cache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: map[string]entry{}, now: time.Now}
+}
+
+// live returns the entry for the key if it exists and hasn't expired. mc.mu must be held.
+func (mc *MemoryCache) live(key string) (entry, bool) {
+	e, ok := mc.entries[key]
+	if !ok {
+		return entry{}, false
+	}
+	if e.ExpiresAt != 0 && e.ExpiresAt <= mc.now().UnixMilli() {
+		delete(mc.entries, key)
+		return entry{}, false
+	}
+	return e, true
+}
+
+// newEntry returns an entry for the value that expires after ttl, or never if ttl is 0.
+func (mc *MemoryCache) newEntry(value any, ttl time.Duration) entry {
+	e := entry{Value: toString(value)}
+	if ttl > 0 {
+		e.ExpiresAt = mc.now().Add(ttl).UnixMilli()
+	}
+	return e
+}
+
+// Set stores a value in the cache.
+func (mc *MemoryCache) Set(_ context.Context, key string, value any) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.entries[key] = entry{Value: toString(value)}
+	return nil
+}
+
+// Get retrieves a value from the cache. Keys that don't exist have an empty value.
+func (mc *MemoryCache) Get(_ context.Context, key string) (any, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	e, _ := mc.live(key)
+	return e.Value, nil
+}
+
+// GetJSON retrieves a JSON string and unmarshals it into the given value.
+func (mc *MemoryCache) GetJSON(ctx context.Context, key string, value any) error {
+	return getJSON(ctx, mc, key, value)
+}
+
+// SetJSON stores a struct as a JSON string.
+func (mc *MemoryCache) SetJSON(ctx context.Context, key string, value any) error {
+	return setJSON(ctx, mc, key, value)
+}
+
+// Delete removes the keys from the cache. Keys that don't exist are ignored.
+func (mc *MemoryCache) Delete(_ context.Context, keys ...string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	for _, k := range keys {
+		delete(mc.entries, k)
+	}
+	return nil
+}
+
+// SetNX atomically stores a value in the cache if the key doesn't already exist and
+// reports whether it was stored. The key expires after ttl, or never if ttl is 0.
+func (mc *MemoryCache) SetNX(_ context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if _, ok := mc.live(key); ok {
+		return false, nil
+	}
+	mc.entries[key] = mc.newEntry(value, ttl)
+	return true, nil
+}
+
+// SetEX stores a value in the cache that expires after ttl, or never if ttl is 0.
+func (mc *MemoryCache) SetEX(_ context.Context, key string, value any, ttl time.Duration) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.entries[key] = mc.newEntry(value, ttl)
+	return nil
+}
+
+// Exists reports whether the key is in the cache.
+func (mc *MemoryCache) Exists(_ context.Context, key string) (bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	_, ok := mc.live(key)
+	return ok, nil
+}
+
+// Keys returns the keys in the cache that start with the prefix, sorted.
+func (mc *MemoryCache) Keys(_ context.Context, prefix string) ([]string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	keys := []string{}
+	for k := range mc.entries {
+		if _, ok := mc.live(k); ok && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// Registers the pure Go "sqlite" driver so builds don't need cgo.
+	_ "modernc.org/sqlite"
+)
+
+// migrations are the statements that create and update the schema, applied in order.
+// The number applied is stored in the database's user_version so add new migrations
+// to the end and never change one that has been released.
+var migrations = []string{
+	`CREATE TABLE cache (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		expires_at INTEGER
+	)`,
+}
+
+// SQLiteCache is a cache held in a SQLite database file.
+type SQLiteCache struct {
+	db *sql.DB
+	// now returns the current time, used to expire keys.
+	now func() time.Time
+}
+
+// NewSQLiteCache opens the SQLite database at the path, creating it if it doesn't exist,
+// and migrates it to the latest schema. Use ":memory:" for a database that isn't saved.
+func NewSQLiteCache(ctx context.Context, path string) (*SQLiteCache, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("opening sqlite database: %w", err)
+	}
+	// SQLite only allows one writer at a time, and each connection to ":memory:" is a new database
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	sc := &SQLiteCache{db: db, now: time.Now}
+	if _, err := db.ExecContext(ctx, `DELETE FROM cache WHERE expires_at <= ?`, sc.now().UnixMilli()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("deleting expired keys: %w", err)
+	}
+	return sc, nil
+}
+
+// migrate applies the migrations the database hasn't had yet.
+func migrate(ctx context.Context, db *sql.DB) error {
+	var version int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("getting schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this version supports (%d)", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("starting migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying migration %d: %w", i+1, err)
+		}
+		// PRAGMA doesn't accept parameters
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("applying migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the database.
+func (sc *SQLiteCache) Close() error {
+	return sc.db.Close()
+}
+
+// Set stores a value in the cache.
+func (sc *SQLiteCache) Set(ctx context.Context, key string, value any) error {
+	_, err := sc.db.ExecContext(ctx, `INSERT INTO cache (key, value, expires_at) VALUES (?, ?, NULL)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = NULL`, key, toString(value))
+	return err
+}
+
+// Get retrieves a value from the cache. Keys that don't exist have an empty value.
+func (sc *SQLiteCache) Get(ctx context.Context, key string) (any, error) {
+	var value string
+	err := sc.db.QueryRowContext(ctx, `SELECT value FROM cache WHERE key = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		key, sc.now().UnixMilli()).Scan(&value)
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		return value, nil
+	}
+	return nil, err
+}
+
+// GetJSON retrieves a JSON string and unmarshals it into the given value.
+func (sc *SQLiteCache) GetJSON(ctx context.Context, key string, value any) error {
+	return getJSON(ctx, sc, key, value)
+}
+
+// SetJSON stores a struct as a JSON string.
+func (sc *SQLiteCache) SetJSON(ctx context.Context, key string, value any) error {
+	return setJSON(ctx, sc, key, value)
+}
+
+// Delete removes the keys from the cache. Keys that don't exist are ignored.
+func (sc *SQLiteCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]any, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+	placeholders := strings.Repeat("?, ", len(keys)-1) + "?"
+	_, err := sc.db.ExecContext(ctx, `DELETE FROM cache WHERE key IN (`+placeholders+`)`, args...) //nolint:gosec // only placeholders are added to the query
+	return err
+}
+
+// SetNX atomically stores a value in the cache if the key doesn't already exist and
+// reports whether it was stored. The key expires after ttl, or never if ttl is 0.
+func (sc *SQLiteCache) SetNX(ctx context.Context, key string, value any, ttl time.Duration) (bool, error) {
+	// Replace the key if it has expired, otherwise leave it alone
+	res, err := sc.db.ExecContext(ctx, `INSERT INTO cache (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at
+		WHERE cache.expires_at IS NOT NULL AND cache.expires_at <= ?`, key, toString(value), sc.expiresAt(ttl), sc.now().UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// SetEX stores a value in the cache that expires after ttl, or never if ttl is 0.
+func (sc *SQLiteCache) SetEX(ctx context.Context, key string, value any, ttl time.Duration) error {
+	_, err := sc.db.ExecContext(ctx, `INSERT INTO cache (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`, key, toString(value), sc.expiresAt(ttl))
+	return err
+}
+
+// Exists reports whether the key is in the cache.
+func (sc *SQLiteCache) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	err := sc.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM cache WHERE key = ? AND (expires_at IS NULL OR expires_at > ?))`,
+		key, sc.now().UnixMilli()).Scan(&exists)
+	return exists, err
+}
+
+// Keys returns the keys in the cache that start with the prefix, sorted.
+func (sc *SQLiteCache) Keys(ctx context.Context, prefix string) ([]string, error) {
+	// substr rather than LIKE as LIKE ignores case and needs escaping
+	rows, err := sc.db.QueryContext(ctx, `SELECT key FROM cache WHERE substr(key, 1, length(?)) = ?
+		AND (expires_at IS NULL OR expires_at > ?) ORDER BY key`, prefix, prefix, sc.now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// expiresAt returns when a key stored now with the ttl expires in Unix milliseconds,
+// or nil if it never does.
+func (sc *SQLiteCache) expiresAt(ttl time.Duration) *int64 {
+	if ttl <= 0 {
+		return nil
+	}
+	ms := sc.now().Add(ttl).UnixMilli()
+	return &ms
+}
+
+// toString formats the value the way Redis stores it.
+func toString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprint(v)
+	}
+}
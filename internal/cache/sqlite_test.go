@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	cache, err := NewSQLiteCache(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set(ctx, "kept", "1"); err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	if err := cache.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("expected schema version %d, got %d", len(migrations), version)
+	}
+	cache.Close()
+
+	// Reopening an up to date database keeps its data
+	cache, err = NewSQLiteCache(ctx, path)
+	if err != nil {
+		t.Fatalf("unexpected error reopening database: %v", err)
+	}
+	if value, _ := cache.Get(ctx, "kept"); value != "1" {
+		t.Errorf("expected data to be kept, got %q", value)
+	}
+	cache.Close()
+
+	// Databases from newer versions are refused
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `PRAGMA user_version = 99`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := NewSQLiteCache(ctx, path); err == nil {
+		t.Error("expected error opening a database with a newer schema")
+	}
+}
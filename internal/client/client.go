@@ -10,6 +10,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 var userAgent = "Stratomagically/0.1"
@@ -17,6 +18,11 @@ var userAgent = "Stratomagically/0.1"
 // Client holds configuration items for the REST client and provides methods that interact with the REST API.
 type Client struct {
 	BaseURL *url.URL
+	// RateLimits tracks the API's rate limit usage. Requests aren't sent while it's used up.
+	// Usage isn't tracked if it's nil.
+	RateLimits *RateLimits
+	// Retry is the policy for retrying failed requests. Requests aren't retried if it's nil.
+	Retry *RetryPolicy
 
 	userAgent string
 	client    *http.Client
@@ -31,10 +37,15 @@ func NewClient(baseURL *url.URL, cc *http.Client) *Client {
 		cc = http.DefaultClient
 	}
 
-	c := &Client{BaseURL: baseURL, userAgent: userAgent, client: cc}
+	c := &Client{BaseURL: baseURL, RateLimits: &RateLimits{}, userAgent: userAgent, client: cc}
 	return c
 }
 
+// Rate returns the API's rate limit usage reported by the most recent response.
+func (c *Client) Rate() Rate {
+	return c.RateLimits.Rate()
+}
+
 // NewRequest creates an HTTP Request. If a non-nil body is provided
 // it will be JSON encoded and included in the request.
 func (c *Client) NewRequest(ctx context.Context, method, urlStr string, body any) (*http.Request, error) {
@@ -71,13 +82,46 @@ func (c *Client) NewRequest(ctx context.Context, method, urlStr string, body any
 
 // Do sends a request and returns the response. An error is returned if the request cannot
 // be sent or if the API returns an error. If a response is received, the body response body
-// is decoded and stored in the value pointed to by v.
+// is decoded and stored in the value pointed to by v. Error responses are returned as an
+// *APIError, or a *RateLimitError if the rate limit has been used up, in which case
+// requests aren't sent until it resets. Failed requests are retried if c.Retry is set.
 func (c *Client) Do(req *http.Request, v any) (*http.Response, error) {
+	for attempts := 1; ; attempts++ {
+		resp, err := c.do(req, v)
+		if c.Retry == nil {
+			return resp, err
+		}
+		delay, ok := c.Retry.retry(req, attempts, err)
+		if !ok {
+			return resp, err
+		}
+
+		logRetry(req, attempts, delay, err)
+		if !Sleep(req.Context(), delay) {
+			return resp, err
+		}
+		if req, err = rewind(req); err != nil {
+			return resp, fmt.Errorf("rewinding request body: %w", err)
+		}
+	}
+}
+
+// do sends the request once.
+func (c *Client) do(req *http.Request, v any) (*http.Response, error) {
+	if err := c.RateLimits.check(time.Now()); err != nil {
+		return nil, err
+	}
+
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
+	rate, hasRate := parseRate(resp.Header, time.Now())
+	if hasRate {
+		c.RateLimits.update(rate)
+	}
+
 	data, err := io.ReadAll(resp.Body)
 	_ = resp.Body.Close()
 
@@ -88,9 +132,20 @@ func (c *Client) Do(req *http.Request, v any) (*http.Response, error) {
 		return resp, fmt.Errorf("reading response body: %w", err)
 	}
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if !hasRate {
+			// Assume the short limit is used up so requests wait for the next window
+			rate = c.RateLimits.Rate()
+			rate.ShortLimit = max(rate.ShortLimit, 1)
+			rate.ShortUsage, rate.Time = rate.ShortLimit, time.Now()
+			c.RateLimits.update(rate)
+		}
+		return resp, &RateLimitError{Rate: rate, Reset: rate.Reset(), Err: newAPIError(resp, data)}
+	}
+
 	// Anything other than a HTTP 2xx response code is treated as an error.
 	if resp.StatusCode >= http.StatusMultipleChoices {
-		return resp, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return resp, newAPIError(resp, data)
 	}
 
 	if v != nil && len(data) != 0 {
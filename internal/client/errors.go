@@ -0,0 +1,84 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIError is returned by Do when the API responds with a status other than 2xx. The
+// message and errors are decoded from a Strava Fault response body if there is one.
+type APIError struct {
+	StatusCode int `json:"-"`
+	// Method and URL identify the request. The URL doesn't include the query as it can hold secrets.
+	Method string `json:"-"`
+	URL    string `json:"-"`
+	// RequestID is the X-Request-Id header from the response, if any, which identifies the request to the API's owner.
+	RequestID string `json:"-"`
+
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+// FieldError describes a problem with a field of a resource, e.g. an invalid gear_id
+// for an Activity.
+type FieldError struct {
+	Resource string `json:"resource"`
+	Field    string `json:"field"`
+	Code     string `json:"code"`
+}
+
+// newAPIError returns the error for the response, decoding what it can from the body.
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{}
+	// Not every error has a JSON body so leave the message empty if it can't be decoded
+	_ = json.Unmarshal(body, e)
+
+	e.StatusCode = resp.StatusCode
+	e.RequestID = resp.Header.Get("X-Request-Id")
+	if req := resp.Request; req != nil {
+		e.Method = req.Method
+		u := *req.URL
+		u.RawQuery, u.User = "", nil
+		e.URL = u.String()
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s: unexpected status %d: %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if len(e.Errors) > 0 {
+		fields := make([]string, 0, len(e.Errors))
+		for _, fe := range e.Errors {
+			fields = append(fields, fmt.Sprintf("%s.%s %s", fe.Resource, fe.Field, fe.Code))
+		}
+		msg += " (" + strings.Join(fields, ", ") + ")"
+	}
+	return msg
+}
+
+// NotFound reports whether the resource doesn't exist or we aren't allowed to see it.
+// Strava responds not found for other athletes' private activities.
+func (e *APIError) NotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// Unauthorized reports whether the API rejected our credentials, e.g. because the
+// athlete revoked our access.
+func (e *APIError) Unauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized
+}
+
+// FieldError returns the error for the field, reporting false if there isn't one.
+func (e *APIError) FieldError(field string) (FieldError, bool) {
+	for _, fe := range e.Errors {
+		if fe.Field == field {
+			return fe, true
+		}
+	}
+	return FieldError{}, false
+}
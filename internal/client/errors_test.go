@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           int
+		body             string
+		wantMessage      string
+		wantNotFound     bool
+		wantUnauthorized bool
+		wantField        string
+		wantCode         string
+	}{
+		{
+			"not found",
+			http.StatusNotFound,
+			`{"message":"Record Not Found","errors":[{"resource":"Activity","field":"id","code":"invalid"}]}`,
+			"Record Not Found", true, false, "id", "invalid",
+		},
+		{
+			"authorization revoked",
+			http.StatusUnauthorized,
+			`{"message":"Authorization Error","errors":[{"resource":"Athlete","field":"access_token","code":"invalid"}]}`,
+			"Authorization Error", false, true, "access_token", "invalid",
+		},
+		{
+			"validation failed",
+			http.StatusBadRequest,
+			`{"message":"Bad Request","errors":[{"resource":"Activity","field":"gear_id","code":"invalid"}]}`,
+			"Bad Request", false, false, "gear_id", "invalid",
+		},
+		{
+			"no body",
+			http.StatusBadGateway,
+			``,
+			"", false, false, "", "",
+		},
+		{
+			"HTML body",
+			http.StatusServiceUnavailable,
+			`<html><body>Service Unavailable</body></html>`,
+			"", false, false, "", "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client, mux, teardown := setup()
+			defer teardown()
+
+			mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("X-Request-Id", "abc123")
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			})
+
+			req, _ := client.NewRequest(context.Background(), "PUT", "activities/1?client_secret=secret", nil)
+			resp, err := client.Do(req, nil)
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			var apiErr *APIError
+			if !errors.As(fmt.Errorf("wrapped: %w", err), &apiErr) {
+				t.Fatalf("expected *APIError, got %v", err)
+			}
+			if apiErr.StatusCode != tc.status || apiErr.Message != tc.wantMessage {
+				t.Errorf("expected %d %q, got %d %q", tc.status, tc.wantMessage, apiErr.StatusCode, apiErr.Message)
+			}
+			if apiErr.Method != "PUT" || !strings.HasSuffix(apiErr.URL, "/activities/1") || apiErr.RequestID != "abc123" {
+				t.Errorf("expected request details without the query, got %s %s %s", apiErr.Method, apiErr.URL, apiErr.RequestID)
+			}
+			if strings.Contains(apiErr.Error(), "secret") {
+				t.Errorf("expected error not to include the query, got %q", apiErr.Error())
+			}
+			if apiErr.NotFound() != tc.wantNotFound || apiErr.Unauthorized() != tc.wantUnauthorized {
+				t.Errorf("expected not found %v and unauthorized %v, got %v and %v", tc.wantNotFound, tc.wantUnauthorized, apiErr.NotFound(), apiErr.Unauthorized())
+			}
+			if tc.wantField != "" {
+				fe, ok := apiErr.FieldError(tc.wantField)
+				if !ok || fe.Code != tc.wantCode {
+					t.Errorf("expected %s error %q, got %+v", tc.wantField, tc.wantCode, apiErr.Errors)
+				}
+				if !strings.Contains(apiErr.Error(), tc.wantField) {
+					t.Errorf("expected error to mention %s, got %q", tc.wantField, apiErr.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitErrorUnwrap(t *testing.T) {
+	client, mux, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"message":"Rate Limit Exceeded"}`)
+	})
+
+	req, _ := client.NewRequest(context.Background(), "GET", ".", nil)
+	resp, err := client.Do(req, nil)
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "Rate Limit Exceeded" {
+		t.Errorf("expected rate limit error to wrap the *APIError, got %v", err)
+	}
+	if errors.Unwrap(&RateLimitError{}) != nil {
+		t.Error("expected rate limit error without a response to wrap nothing")
+	}
+}
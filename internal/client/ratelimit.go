@@ -0,0 +1,138 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shortWindow is the length of the short rate limit window. Windows start on the quarter hour.
+const shortWindow = 15 * time.Minute
+
+// Rate is the rate limit usage reported in a response's X-RateLimit-Limit and
+// X-RateLimit-Usage headers. Strava limits the requests made every 15 minutes and every day.
+type Rate struct {
+	// ShortLimit and ShortUsage are the requests allowed and made in the current 15 minute window.
+	ShortLimit int `json:"short_limit"`
+	ShortUsage int `json:"short_usage"`
+	// DailyLimit and DailyUsage are the requests allowed and made since midnight UTC.
+	DailyLimit int `json:"daily_limit"`
+	DailyUsage int `json:"daily_usage"`
+	// Time is when the rate was reported.
+	Time time.Time `json:"time"`
+}
+
+// parseRate returns the rate in the response headers, reporting false if there isn't one.
+func parseRate(h http.Header, t time.Time) (Rate, bool) {
+	shortLimit, dailyLimit, ok := parsePair(h.Get("X-RateLimit-Limit"))
+	if !ok {
+		return Rate{}, false
+	}
+	shortUsage, dailyUsage, ok := parsePair(h.Get("X-RateLimit-Usage"))
+	if !ok {
+		return Rate{}, false
+	}
+	return Rate{ShortLimit: shortLimit, ShortUsage: shortUsage, DailyLimit: dailyLimit, DailyUsage: dailyUsage, Time: t}, true
+}
+
+// parsePair parses a header value of two comma separated integers.
+func parsePair(s string) (first, second int, ok bool) {
+	a, b, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, false
+	}
+	first, err := strconv.Atoi(strings.TrimSpace(a))
+	if err != nil {
+		return 0, 0, false
+	}
+	second, err = strconv.Atoi(strings.TrimSpace(b))
+	if err != nil {
+		return 0, 0, false
+	}
+	return first, second, true
+}
+
+// Exceeded reports whether the short or daily limit has been used up.
+func (r Rate) Exceeded() bool {
+	return r.dailyExceeded() || (r.ShortLimit > 0 && r.ShortUsage >= r.ShortLimit)
+}
+
+func (r Rate) dailyExceeded() bool {
+	return r.DailyLimit > 0 && r.DailyUsage >= r.DailyLimit
+}
+
+// Reset returns when requests can be made again: the end of the day if the daily limit
+// has been used up, or the end of the current 15 minute window otherwise.
+func (r Rate) Reset() time.Time {
+	t := r.Time.UTC()
+	if r.dailyExceeded() {
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(shortWindow).Add(shortWindow)
+}
+
+// RateLimitError is returned when the API's rate limit has been exceeded, either by a
+// 429 Too Many Requests response or because the last response said it had been used up.
+type RateLimitError struct {
+	Rate Rate
+	// Reset is when requests can be made again.
+	Reset time.Time
+	// Err is the error response, or nil if the request wasn't sent.
+	Err *APIError
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded (%d/%d requests in 15 minutes, %d/%d today): resets at %s",
+		e.Rate.ShortUsage, e.Rate.ShortLimit, e.Rate.DailyUsage, e.Rate.DailyLimit, e.Reset.Format(time.RFC3339))
+}
+
+func (e *RateLimitError) Unwrap() error {
+	if e.Err == nil {
+		return nil
+	}
+	return e.Err
+}
+
+// RateLimits tracks an API's rate limit usage from the responses to requests. Rate limits
+// usually apply to an application rather than a client so share one between clients for
+// the same API. The zero value is ready to use and a nil *RateLimits tracks nothing.
+type RateLimits struct {
+	mu   sync.Mutex
+	rate Rate
+}
+
+// Rate returns the rate reported by the most recent response.
+func (rl *RateLimits) Rate() Rate {
+	if rl == nil {
+		return Rate{}
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.rate
+}
+
+// update records the rate reported by a response, ignoring rates older than the one we have
+// as concurrent requests can finish in any order.
+func (rl *RateLimits) update(r Rate) {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if r.Time.Before(rl.rate.Time) {
+		return
+	}
+	rl.rate = r
+}
+
+// check returns a *RateLimitError if the limit has been used up and hasn't reset yet.
+func (rl *RateLimits) check(now time.Time) error {
+	r := rl.Rate()
+	if r.Exceeded() && now.Before(r.Reset()) {
+		return &RateLimitError{Rate: r, Reset: r.Reset()}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 20, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		limit  string
+		usage  string
+		want   Rate
+		wantOK bool
+	}{
+		{"rate", "200,2000", "13,1032", Rate{ShortLimit: 200, ShortUsage: 13, DailyLimit: 2000, DailyUsage: 1032, Time: now}, true},
+		{"spaces", "200, 2000", "13, 1032", Rate{ShortLimit: 200, ShortUsage: 13, DailyLimit: 2000, DailyUsage: 1032, Time: now}, true},
+		{"missing headers", "", "", Rate{}, false},
+		{"malformed limit", "200", "13,1032", Rate{}, false},
+		{"malformed usage", "200,2000", "a,b", Rate{}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.Header{}
+			h.Set("X-RateLimit-Limit", tc.limit)
+			h.Set("X-RateLimit-Usage", tc.usage)
+			got, ok := parseRate(h, now)
+			if ok != tc.wantOK || got != tc.want {
+				t.Errorf("expected %+v, %v, got %+v, %v", tc.want, tc.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestRateReset(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 20, 31, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		rate         Rate
+		wantExceeded bool
+		wantReset    time.Time
+	}{
+		{"under limits", Rate{ShortLimit: 200, ShortUsage: 13, DailyLimit: 2000, DailyUsage: 1032, Time: at}, false, time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)},
+		{"short limit used up", Rate{ShortLimit: 200, ShortUsage: 200, DailyLimit: 2000, DailyUsage: 1032, Time: at}, true, time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)},
+		{"daily limit used up", Rate{ShortLimit: 200, ShortUsage: 13, DailyLimit: 2000, DailyUsage: 2000, Time: at}, true, time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"no limits", Rate{Time: at}, false, time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.rate.Exceeded(); got != tc.wantExceeded {
+				t.Errorf("expected exceeded %v, got %v", tc.wantExceeded, got)
+			}
+			if got := tc.rate.Reset(); !got.Equal(tc.wantReset) {
+				t.Errorf("expected reset at %v, got %v", tc.wantReset, got)
+			}
+		})
+	}
+}
+
+func TestDoRateLimits(t *testing.T) {
+	t.Run("usage is tracked", func(t *testing.T) {
+		client, mux, teardown := setup()
+		defer teardown()
+
+		mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("X-RateLimit-Limit", "200,2000")
+			w.Header().Set("X-RateLimit-Usage", "13,1032")
+			fmt.Fprint(w, `{}`)
+		})
+
+		req, _ := client.NewRequest(context.Background(), "GET", ".", nil)
+		resp, err := client.Do(req, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+
+		if r := client.Rate(); r.ShortUsage != 13 || r.DailyUsage != 1032 || r.ShortLimit != 200 || r.DailyLimit != 2000 {
+			t.Errorf("expected usage to be tracked, got %+v", r)
+		}
+	})
+
+	t.Run("too many requests", func(t *testing.T) {
+		client, mux, teardown := setup()
+		defer teardown()
+
+		requests := 0
+		mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+			requests++
+			w.Header().Set("X-RateLimit-Limit", "200,2000")
+			w.Header().Set("X-RateLimit-Usage", "201,1032")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"message":"Rate Limit Exceeded"}`)
+		})
+
+		req, _ := client.NewRequest(context.Background(), "GET", ".", nil)
+		resp, err := client.Do(req, nil)
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		var rle *RateLimitError
+		if !errors.As(err, &rle) {
+			t.Fatalf("expected *RateLimitError, got %v", err)
+		}
+		if !rle.Reset.After(time.Now()) || rle.Reset.Sub(time.Now()) > shortWindow {
+			t.Errorf("expected reset within the 15 minute window, got %v", rle.Reset)
+		}
+
+		// The limit is used up so the next request isn't sent
+		req, _ = client.NewRequest(context.Background(), "GET", ".", nil)
+		resp, err = client.Do(req, nil)
+		if resp != nil {
+			resp.Body.Close()
+			t.Error("expected no response")
+		}
+		if !errors.As(err, &rle) {
+			t.Errorf("expected *RateLimitError, got %v", err)
+		}
+		if requests != 1 {
+			t.Errorf("expected 1 request to be sent, got %d", requests)
+		}
+	})
+
+	t.Run("too many requests without headers", func(t *testing.T) {
+		client, mux, teardown := setup()
+		defer teardown()
+
+		mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		})
+
+		req, _ := client.NewRequest(context.Background(), "GET", ".", nil)
+		resp, err := client.Do(req, nil)
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		var rle *RateLimitError
+		if !errors.As(err, &rle) || rle.Reset.IsZero() {
+			t.Errorf("expected *RateLimitError with a reset time, got %v", err)
+		}
+
+		// The limit is assumed to be used up so the next request isn't sent
+		if err := client.RateLimits.check(time.Now()); !errors.As(err, &rle) {
+			t.Errorf("expected *RateLimitError, got %v", err)
+		}
+	})
+
+	t.Run("client without rate limits", func(t *testing.T) {
+		nc, mux, teardown := setup()
+		defer teardown()
+
+		requests := 0
+		mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusTooManyRequests)
+		})
+
+		client := &Client{BaseURL: nc.BaseURL, client: http.DefaultClient}
+		for range 2 {
+			req, _ := client.NewRequest(context.Background(), "GET", ".", nil)
+			resp, err := client.Do(req, nil)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			var rle *RateLimitError
+			if !errors.As(err, &rle) {
+				t.Errorf("expected *RateLimitError, got %v", err)
+			}
+		}
+		if requests != 2 {
+			t.Errorf("expected every request to be sent without tracking the usage, got %d", requests)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries requests that fail with errors that are likely to be transient:
+// timeouts, connection resets and 502, 503 and 504 responses. Requests that are safe to
+// repeat, like GET, are retried for any of these but others, like PUT, are only retried
+// if the API can't have acted on them: when we couldn't connect or the API responded 503.
+// Retries stop early if waiting would go past the request context's deadline.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is sent, including the first. Defaults to 3.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles for every retry after that,
+	// with random jitter so clients don't retry in step. Defaults to 250ms.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries. Defaults to 5s.
+	MaxBackoff time.Duration
+}
+
+// delay returns the delay before the retry after the given number of attempts: a random
+// duration between half and all of the exponential backoff.
+func (p *RetryPolicy) delay(attempts int) time.Duration {
+	base, maxDelay := p.Backoff, p.MaxBackoff
+	if base <= 0 {
+		base = 250 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Second
+	}
+
+	d := Backoff(base, maxDelay, attempts)
+	return d/2 + rand.N(d/2+1) //nolint:gosec // G404: jitter doesn't need a secure random number
+}
+
+// Backoff returns the exponential backoff after the given number of failed attempts: base
+// after the first, doubling for every attempt after that, capped at maxDelay.
+func Backoff(base, maxDelay time.Duration, attempts int) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < maxDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxDelay)
+}
+
+// retry returns how long to wait before sending the request again after the attempt
+// failed with err, reporting false if it shouldn't be.
+func (p *RetryPolicy) retry(req *http.Request, attempts int, err error) (time.Duration, bool) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	if err == nil || attempts >= maxAttempts || req.Context().Err() != nil {
+		return 0, false
+	}
+	// The body can only be sent again if it can be read again
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false
+	}
+
+	if idempotent(req.Method) {
+		if !transient(err) {
+			return 0, false
+		}
+	} else if !unsent(err) {
+		return 0, false
+	}
+
+	d := p.delay(attempts)
+	if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < d {
+		return 0, false
+	}
+	return d, true
+}
+
+// idempotent reports whether sending a request with the method more than once has the same effect as sending it once.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// transient reports whether the error is likely to go away if the request is sent again.
+func transient(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		// A rate limit error wraps an *APIError but waits for the limit to reset instead
+		var rle *RateLimitError
+		if errors.As(err, &rle) {
+			return false
+		}
+		switch apiErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return unsent(err) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// unsent reports whether the error means the API can't have acted on the request.
+func unsent(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		var rle *RateLimitError
+		return !errors.As(err, &rle) && apiErr.StatusCode == http.StatusServiceUnavailable
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// rewind returns a copy of the request with a fresh body so it can be sent again.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.GetBody == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Body = body
+	return r, nil
+}
+
+// Sleep waits for d, returning false if ctx is done first.
+func Sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// logRetry logs that the request is being retried.
+func logRetry(req *http.Request, attempts int, delay time.Duration, err error) {
+	u := *req.URL
+	u.RawQuery, u.User = "", nil
+	// Transport errors include the URL, query and all
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	slog.Warn("request failed, retrying", "method", req.Method, "url", u.String(), "attempts", attempts, "retry_in", delay, "error", err) //nolint:gosec // G706 noise
+}
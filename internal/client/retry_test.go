@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+// step is the outcome of one attempt: a response status or, if zero, a transport error.
+type step struct {
+	status int
+	err    error
+}
+
+var (
+	errRefused = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	errReset   = &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+)
+
+func TestRetry(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	tests := []struct {
+		name      string
+		method    string
+		steps     []step
+		policy    *RetryPolicy
+		wantCalls []string
+		wantErr   bool
+	}{
+		{
+			"no policy",
+			http.MethodGet,
+			[]step{{status: 503}, {status: 200}},
+			nil,
+			[]string{"GET 503"},
+			true,
+		},
+		{
+			"GET retried after gateway errors",
+			http.MethodGet,
+			[]step{{status: 502}, {status: 504}, {status: 200}},
+			&RetryPolicy{Backoff: time.Millisecond},
+			[]string{"GET 502", "GET 504", "GET 200"},
+			false,
+		},
+		{
+			"GET retried after connection reset",
+			http.MethodGet,
+			[]step{{err: errReset}, {status: 200}},
+			&RetryPolicy{Backoff: time.Millisecond},
+			[]string{"GET reset", "GET 200"},
+			false,
+		},
+		{
+			"GET gives up after max attempts",
+			http.MethodGet,
+			[]step{{status: 503}, {status: 503}, {status: 503}},
+			&RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond},
+			[]string{"GET 503", "GET 503"},
+			true,
+		},
+		{
+			"GET not retried after client error",
+			http.MethodGet,
+			[]step{{status: 404}, {status: 200}},
+			&RetryPolicy{Backoff: time.Millisecond},
+			[]string{"GET 404"},
+			true,
+		},
+		{
+			"GET not retried after server error",
+			http.MethodGet,
+			[]step{{status: 500}, {status: 200}},
+			&RetryPolicy{Backoff: time.Millisecond},
+			[]string{"GET 500"},
+			true,
+		},
+		{
+			"GET not retried after rate limit",
+			http.MethodGet,
+			[]step{{status: 429}, {status: 200}},
+			&RetryPolicy{Backoff: time.Millisecond},
+			[]string{"GET 429"},
+			true,
+		},
+		{
+			"PUT retried when it couldn't connect",
+			http.MethodPut,
+			[]step{{err: errRefused}, {status: 503}, {status: 200}},
+			&RetryPolicy{Backoff: time.Millisecond},
+			[]string{"PUT refused", "PUT 503", "PUT 200"},
+			false,
+		},
+		{
+			"PUT not retried after connection reset",
+			http.MethodPut,
+			[]step{{err: errReset}, {status: 200}},
+			&RetryPolicy{Backoff: time.Millisecond},
+			[]string{"PUT reset"},
+			true,
+		},
+		{
+			"PUT not retried after gateway error",
+			http.MethodPut,
+			[]step{{status: 502}, {status: 200}},
+			&RetryPolicy{Backoff: time.Millisecond},
+			[]string{"PUT 502"},
+			true,
+		},
+		{
+			"retries stop at the context deadline",
+			http.MethodGet,
+			[]step{{status: 503}, {status: 200}},
+			&RetryPolicy{Backoff: time.Hour, MaxBackoff: time.Hour},
+			[]string{"GET 503"},
+			true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			httpmock.Reset()
+			var calls, bodies []string
+			httpmock.RegisterResponder(tc.method, "https://example.com/activities/1", func(req *http.Request) (*http.Response, error) {
+				s := tc.steps[len(calls)]
+				if req.Body != nil {
+					b, _ := io.ReadAll(req.Body)
+					bodies = append(bodies, string(b))
+				}
+				switch {
+				case errors.Is(s.err, syscall.ECONNREFUSED):
+					calls = append(calls, tc.method+" refused")
+					return nil, s.err
+				case s.err != nil:
+					calls = append(calls, tc.method+" reset")
+					return nil, s.err
+				}
+				calls = append(calls, tc.method+" "+strconv.Itoa(s.status))
+				return httpmock.NewStringResponse(s.status, `{}`), nil
+			})
+
+			c := NewClient(&url.URL{Scheme: "https", Host: "example.com", Path: "/"}, nil)
+			c.Retry = tc.policy
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			var body any
+			if tc.method == http.MethodPut {
+				body = map[string]string{"name": "Morning Ride"}
+			}
+			req, _ := c.NewRequest(ctx, tc.method, "activities/1", body)
+			resp, err := c.Do(req, nil)
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if !reflect.DeepEqual(calls, tc.wantCalls) {
+				t.Errorf("expected attempts %v, got %v", tc.wantCalls, calls)
+			}
+			// Every attempt must send the whole body
+			for _, b := range bodies {
+				if tc.method == http.MethodPut && b != `{"name":"Morning Ride"}`+"\n" {
+					t.Errorf("expected the body to be sent again, got %q", b)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := &RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempts, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 5: time.Second} {
+		for range 20 {
+			if got := p.delay(attempts); got < want/2 || got > want {
+				t.Errorf("attempt %d: expected delay between %v and %v, got %v", attempts, want/2, want, got)
+			}
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := Backoff(time.Second, 5*time.Second, attempts); got != want {
+			t.Errorf("attempt %d: expected %v, got %v", attempts, want, got)
+		}
+	}
+}
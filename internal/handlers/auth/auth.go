@@ -9,14 +9,22 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/lildude/strautomagically/internal/cache"
 	"github.com/lildude/strautomagically/internal/strava"
-	"golang.org/x/oauth2"
 )
 
 const oauthStateCookie = "oauth_state"
 
+// stateTTL is how long the athlete has to authorize us on Strava.
+const stateTTL = 10 * time.Minute
+
+// stateKey returns the cache key recording that the OAuth state was issued by us.
+func stateKey(state string) string {
+	return "oauth_state:" + state
+}
+
 // newStateCookie returns an http.Cookie for the OAuth state with standard security attributes.
 // The Secure flag is set only when the request arrived over HTTPS.
 func newStateCookie(r *http.Request, value string, maxAge int) *http.Cookie {
@@ -40,35 +48,32 @@ func AuthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	state := r.Form.Get("state")
-	che, err := cache.NewRedisCache(r.Context(), os.Getenv("REDIS_URL"))
+	che, err := cache.Open(r.Context(), cache.URL())
 	if err != nil {
-		slog.Error("unable to create redis cache", "error", err)
+		slog.Error("unable to open cache", "error", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	authToken := &oauth2.Token{}
-	if err := che.GetJSON(r.Context(), "strava_auth_token", &authToken); err != nil {
-		slog.Warn("unable to get cached auth token", "error", err)
-	}
-
+	// Every visit without a state starts authorization as we don't know which athlete is visiting
 	if state == "" {
-		if authToken.AccessToken == "" {
-			// Generate a cryptographically random per-request state to prevent CSRF attacks.
-			b := make([]byte, 16)
-			if _, err := rand.Read(b); err != nil {
-				slog.Error("failed to generate OAuth state", "error", err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-			oauthState := hex.EncodeToString(b)
-			http.SetCookie(w, newStateCookie(r, oauthState, 600))
-			u := strava.OauthConfig.AuthCodeURL(oauthState)
-			slog.Info("redirecting to strava auth", "state_len", len(oauthState))
-			http.Redirect(w, r, u, http.StatusFound)
-		} else {
-			http.Redirect(w, r, "/start", http.StatusFound)
+		// Generate a cryptographically random per-request state to prevent CSRF attacks.
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			slog.Error("failed to generate OAuth state", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		oauthState := hex.EncodeToString(b)
+		if err := che.SetEX(r.Context(), stateKey(oauthState), time.Now().Unix(), stateTTL); err != nil {
+			slog.Error("unable to store OAuth state", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
 		}
+		http.SetCookie(w, newStateCookie(r, oauthState, int(stateTTL.Seconds())))
+		u := strava.OauthConfig.AuthCodeURL(oauthState)
+		slog.Info("redirecting to strava auth", "state_len", len(oauthState))
+		http.Redirect(w, r, u, http.StatusFound)
 		return
 	}
 
@@ -90,7 +95,22 @@ func AuthHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "state invalid", http.StatusBadRequest)
 		return
 	}
-	// Clear the state cookie immediately after successful validation to prevent reuse.
+	// The state must have been issued by us and not have expired or been used
+	issued, err := che.Exists(r.Context(), stateKey(state))
+	if err != nil {
+		slog.Error("unable to get OAuth state", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !issued {
+		slog.Warn("oauth state unknown or expired")
+		http.Error(w, "state invalid", http.StatusBadRequest)
+		return
+	}
+	// Clear the state immediately after successful validation to prevent reuse.
+	if err := che.Delete(r.Context(), stateKey(state)); err != nil {
+		slog.Error("unable to delete OAuth state", "error", err)
+	}
 	http.SetCookie(w, newStateCookie(r, "", -1))
 	code := r.Form.Get("code")
 	if code == "" {
@@ -110,23 +130,36 @@ func AuthHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	athleteID, ok := strava.AthleteID(token)
+	if !ok {
+		slog.Error("unable to get athlete ID")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
 
-	err = che.SetJSON(r.Context(), "strava_auth_token", token)
+	err = strava.SaveToken(r.Context(), che, athleteID, token)
 	if err != nil {
 		slog.Error("unable to store token", "error", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	slog.Info("successfully authenticated", "username", athlete["username"])
+	slog.Info("successfully authenticated", "username", athlete["username"], "athlete_id", athleteID)
+
+	// Process events for the athlete again if they'd previously revoked access
+	if err := che.Delete(r.Context(), strava.DeauthorizedKey(athleteID)); err != nil {
+		slog.Error("unable to clear deauthorization", "error", err)
+	}
 
 	// Subscribe to the activity stream - should this be here?
-	ok, err = Subscribe(r.Context())
-	if !ok {
+	sub, created, err := strava.EnsureSubscribed(r.Context(), che, os.Getenv("STRAVA_CALLBACK_URI"))
+	if err != nil {
 		slog.Error("failed to subscribe to strava webhook", "error", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	slog.Info("successfully subscribed to strava activity feed")
+	if created {
+		slog.Info("successfully subscribed to strava activity feed", "subscription_id", sub.ID)
+	}
 
 	http.Redirect(w, r, "/start", http.StatusFound)
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/jarcoal/httpmock"
+	"github.com/lildude/strautomagically/internal/strava"
 )
 
 func TestAuthHandler(t *testing.T) {
@@ -33,22 +34,26 @@ func TestAuthHandler(t *testing.T) {
 		httpmock.NewStringResponder(200, oat))
 
 	httpmock.RegisterResponder("GET", "https://www.strava.com/api/v3/push_subscriptions",
-		httpmock.NewStringResponder(200, `[{}]`))
+		httpmock.NewStringResponder(200, `[]`))
 
 	httpmock.RegisterResponder("POST", "https://www.strava.com/api/v3/push_subscriptions",
-		httpmock.NewStringResponder(200, `{"id":1}`))
+		httpmock.NewStringResponder(201, `{"id":1}`))
 
 	r := miniredis.RunT(t)
 	defer r.Close()
 	t.Setenv("REDIS_URL", "redis://"+r.Addr())
+	t.Setenv("STRAVA_CALLBACK_URI", "https://example.com/webhook")
+	r.Set(strava.DeauthorizedKey(1), `{"athlete_id":1}`)
 
 	const testValidState = "abc123def456ghi7"
+	const testUsedState = "usedusedusedused"
 
 	tests := []struct {
 		name        string
 		query       string
 		body        string
 		stateCookie string
+		issued      bool
 		want        int
 	}{
 		{
@@ -63,16 +68,24 @@ func TestAuthHandler(t *testing.T) {
 			stateCookie: testValidState,
 			want:        http.StatusBadRequest,
 		},
+		{
+			name:        "state we didn't issue or has expired",
+			query:       "?state=" + testUsedState + "&code=test-code",
+			stateCookie: testUsedState,
+			want:        http.StatusBadRequest,
+		},
 		{
 			name:        "valid state but no code",
 			query:       "?state=" + testValidState,
 			stateCookie: testValidState,
+			issued:      true,
 			want:        http.StatusBadRequest,
 		},
 		{
 			name:        "valid state and code",
 			query:       "?state=" + testValidState + "&code=test-code",
 			stateCookie: testValidState,
+			issued:      true,
 			want:        http.StatusFound,
 		},
 	}
@@ -86,6 +99,9 @@ func TestAuthHandler(t *testing.T) {
 			if tc.stateCookie != "" {
 				req.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: tc.stateCookie})
 			}
+			if tc.issued {
+				r.Set(stateKey(tc.stateCookie), "1")
+			}
 			rr := httptest.NewRecorder()
 			handler := http.HandlerFunc(AuthHandler)
 			handler.ServeHTTP(rr, req)
@@ -93,6 +109,27 @@ func TestAuthHandler(t *testing.T) {
 			if status := rr.Code; status != tc.want {
 				t.Errorf("%s: handler returned wrong status code: got %d want %d", tc.name, status, tc.want)
 			}
+
+			// States we issue expire and are removed once they've been used
+			if tc.query == "" {
+				cookies := rr.Result().Cookies()
+				if len(cookies) != 1 || r.TTL(stateKey(cookies[0].Value)) != stateTTL {
+					t.Errorf("expected state in the cookie to be stored with a TTL, got keys %v", r.Keys())
+				}
+			}
+			if tc.issued && r.Exists(stateKey(tc.stateCookie)) {
+				t.Error("expected used state to be deleted")
+			}
 		})
 	}
+
+	if n := httpmock.GetCallCountInfo()["POST https://www.strava.com/api/v3/push_subscriptions"]; n != 1 {
+		t.Errorf("expected webhook subscription to be created once, got %d", n)
+	}
+	if !r.Exists(strava.TokenKey(1)) {
+		t.Error("expected token to be stored for the athlete")
+	}
+	if r.Exists(strava.DeauthorizedKey(1)) {
+		t.Error("expected deauthorization to be cleared after authorizing")
+	}
 }
@@ -5,9 +5,14 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
-	"os"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/strava"
 )
 
+// CallbackHandler answers Strava's request to verify the callback URL when a webhook
+// subscription is created. Strava sends the verify token we generated for the subscription,
+// which must still be in the cache.
 func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	challenge, ok := q["hub.challenge"]
@@ -20,7 +25,20 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing query param: hub.verify_token", http.StatusBadRequest)
 		return
 	}
-	if verify[0] != os.Getenv("STRAVA_VERIFY_TOKEN") { // TODO: generate this
+
+	c, err := cache.Open(r.Context(), cache.URL())
+	if err != nil {
+		slog.Error("opening cache", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	valid, err := strava.ValidVerifyToken(r.Context(), c, verify[0])
+	if err != nil {
+		slog.Error("checking verify token", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !valid {
 		http.Error(w, "verify token mismatch", http.StatusBadRequest)
 		return
 	}
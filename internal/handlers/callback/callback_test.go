@@ -5,6 +5,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
 )
 
 func TestCallbackHandler(t *testing.T) {
@@ -33,11 +36,24 @@ func TestCallbackHandler(t *testing.T) {
 			"hub.mode=subscribe&hub.challenge=mychallenge&hub.verify_token=wrong",
 			http.StatusBadRequest,
 		},
+		{
+			"verify token mismatch",
+			"hub.mode=subscribe&hub.challenge=mychallenge&hub.verify_token=expired",
+			http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			t.Setenv("STRAVA_VERIFY_TOKEN", "mytoken")
+			r := miniredis.RunT(t)
+			t.Setenv("REDIS_URL", "redis://"+r.Addr())
+			// Tokens generated for a subscription that's being created
+			r.Set("strava_verify_token:mytoken", "1")
+			r.SetTTL("strava_verify_token:mytoken", 5*time.Minute)
+			r.Set("strava_verify_token:expired", "1")
+			r.SetTTL("strava_verify_token:expired", time.Minute)
+			r.FastForward(2 * time.Minute)
+
 			req := httptest.NewRequest(http.MethodGet, "/?"+tt.queryParams, http.NoBody)
 			w := httptest.NewRecorder()
 			CallbackHandler(w, req)
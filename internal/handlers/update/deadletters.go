@@ -0,0 +1,119 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/queue"
+)
+
+// deadLetters returns the dead letter store in the cache.
+func deadLetters(ctx context.Context) (*queue.DeadLetters, error) {
+	rcache, err := cache.Open(ctx, cache.URL())
+	if err != nil {
+		return nil, fmt.Errorf("opening cache: %w", err)
+	}
+	return queue.NewDeadLetters(rcache), nil
+}
+
+// StoreDeadLetter stores a job that failed on its last attempt so it can be replayed later.
+func StoreDeadLetter(ctx context.Context, job *queue.Job, jobErr error) {
+	dls, err := deadLetters(ctx)
+	if err != nil {
+		slog.Error("unable to store dead letter", "error", err, "id", job.Event.ObjectID)
+		return
+	}
+	dl, err := dls.Add(ctx, job, jobErr)
+	if err != nil {
+		slog.Error("unable to store dead letter", "error", err, "id", job.Event.ObjectID)
+		return
+	}
+	slog.Warn("stored dead letter", "dead_letter", dl.ID, "error", dl.Error) //nolint:gosec // G706 noise
+}
+
+// ListDeadLetters returns the stored dead letters, oldest first.
+func ListDeadLetters(ctx context.Context) ([]*queue.DeadLetter, error) {
+	dls, err := deadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dls.List(ctx)
+}
+
+// GetDeadLetter returns the dead letter with the ID.
+func GetDeadLetter(ctx context.Context, id string) (*queue.DeadLetter, error) {
+	dls, err := deadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dls.Get(ctx, id)
+}
+
+// ReplayDeadLetter adds the dead letter's event back to the queue, or processes it
+// straight away if there's no queue, and deletes the dead letter.
+func ReplayDeadLetter(ctx context.Context, id string) error {
+	dls, err := deadLetters(ctx)
+	if err != nil {
+		return err
+	}
+	return dls.Replay(ctx, id, replayJob)
+}
+
+func replayJob(ctx context.Context, job *queue.Job) error {
+	if jobs != nil {
+		return jobs.Enqueue(ctx, job)
+	}
+	return ProcessJob(ctx, job)
+}
+
+// DeadLettersHandler lists the dead letters, or shows the one in the id query parameter
+// for GET requests and replays it for POST requests. Requests must include the ADMIN_TOKEN
+// as a bearer token.
+func DeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	var resp any
+	var err error
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		resp, err = ListDeadLetters(r.Context())
+	case r.Method == http.MethodGet:
+		resp, err = GetDeadLetter(r.Context(), id)
+	case r.Method == http.MethodPost && id != "":
+		if err = ReplayDeadLetter(r.Context(), id); err == nil {
+			resp = map[string]string{"replayed": id}
+		}
+	case r.Method == http.MethodPost:
+		http.Error(w, "missing dead letter id", http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if errors.Is(err, queue.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("dead letter request failed", "error", sanitizeForLog(err.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		slog.Error("encoding dead letters", "error", err)
+	}
+}
@@ -0,0 +1,107 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/queue"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+func TestDeadLettersHandler(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	r := miniredis.RunT(t)
+	defer r.Close()
+	t.Setenv("REDIS_URL", "redis://"+r.Addr())
+	t.Setenv("ADMIN_TOKEN", "admin")
+	ctx := context.Background()
+
+	StoreDeadLetter(ctx, &queue.Job{Event: strava.WebhookPayload{ObjectID: 123, AspectType: "create", OwnerID: 1, EventTime: 1000}, Attempts: 5}, errors.New("boom"))
+	StoreDeadLetter(ctx, &queue.Job{Event: strava.WebhookPayload{ObjectID: 456, AspectType: "create", OwnerID: 1, EventTime: 1001}, Attempts: 5}, errors.New("boom"))
+
+	tests := []struct {
+		name     string
+		method   string
+		query    string
+		token    string
+		wantCode int
+		wantIDs  []string
+	}{
+		{"no token", http.MethodGet, "", "", http.StatusNotFound, nil},
+		{"list", http.MethodGet, "", "admin", http.StatusOK, []string{"123-create-1000", "456-create-1001"}},
+		{"show", http.MethodGet, "?id=456-create-1001", "admin", http.StatusOK, []string{"456-create-1001"}},
+		{"show missing", http.MethodGet, "?id=789-create-1", "admin", http.StatusNotFound, nil},
+		{"replay without id", http.MethodPost, "", "admin", http.StatusBadRequest, nil},
+		{"replay missing", http.MethodPost, "?id=789-create-1", "admin", http.StatusNotFound, nil},
+		// There's no token for the athlete so processing the event fails again
+		{"replay failure", http.MethodPost, "?id=456-create-1001", "admin", http.StatusInternalServerError, nil},
+		{"replay", http.MethodPost, "?id=123-create-1000", "admin", http.StatusOK, nil},
+		{"list after replay", http.MethodGet, "", "admin", http.StatusOK, []string{"456-create-1001"}},
+	}
+
+	q := queue.NewMemoryQueue(1)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Replay successfully by queuing the event
+			if tc.name == "replay" {
+				SetQueue(q)
+				defer SetQueue(nil)
+			}
+
+			req := httptest.NewRequest(tc.method, "/deadletters"+tc.query, http.NoBody)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rr := httptest.NewRecorder()
+			DeadLettersHandler(rr, req)
+
+			if rr.Code != tc.wantCode {
+				t.Fatalf("expected status %d, got %d: %s", tc.wantCode, rr.Code, rr.Body.String())
+			}
+			if tc.wantIDs == nil {
+				return
+			}
+
+			var got []*queue.DeadLetter
+			if len(tc.wantIDs) == 1 && tc.query != "" {
+				var dl queue.DeadLetter
+				if err := json.Unmarshal(rr.Body.Bytes(), &dl); err != nil {
+					t.Fatalf("unexpected error decoding response: %v", err)
+				}
+				got = append(got, &dl)
+			} else if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unexpected error decoding response: %v", err)
+			}
+			if len(got) != len(tc.wantIDs) {
+				t.Fatalf("expected %d dead letters, got %d", len(tc.wantIDs), len(got))
+			}
+			for i, id := range tc.wantIDs {
+				if got[i].ID != id || got[i].Error != "boom" {
+					t.Errorf("expected dead letter %s with error boom, got %+v", id, got[i])
+				}
+			}
+		})
+	}
+
+	job, err := q.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error dequeuing replayed job: %v", err)
+	}
+	if job.Event.ObjectID != 123 || job.Attempts != 0 {
+		t.Errorf("expected activity 123 to be queued with attempts reset, got %+v", job)
+	}
+
+	rcache, _ := cache.NewRedisCache(ctx, "redis://"+r.Addr())
+	if _, err := queue.NewDeadLetters(rcache).Get(ctx, "456-create-1001"); err != nil {
+		t.Errorf("expected failed replay to keep the dead letter, got %v", err)
+	}
+}
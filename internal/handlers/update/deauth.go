@@ -0,0 +1,96 @@
+package update
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/queue"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+// deauthorization is the audit record stored when an athlete revokes our access.
+type deauthorization struct {
+	AthleteID int64 `json:"athlete_id"`
+	// EventTime is when the athlete revoked access, from the webhook event.
+	EventTime int64 `json:"event_time"`
+	// Time is when we processed the event.
+	Time int64 `json:"time"`
+	// Deleted lists the cache keys deleted.
+	Deleted []string `json:"deleted"`
+}
+
+// athleteKeys returns the cache keys holding data for the athlete: their token and the
+// data for the activities we've updated or stored dead letters for. Events for their
+// other activities don't record the athlete and expire on their own.
+func athleteKeys(ctx context.Context, c cache.Cache, athleteID int64) ([]string, error) {
+	var ids []int64
+	updates, err := c.Keys(ctx, appliedUpdatePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing activity updates: %w", err)
+	}
+	for _, key := range updates {
+		var applied appliedUpdate
+		if err := c.GetJSON(ctx, key, &applied); err != nil {
+			return nil, fmt.Errorf("getting activity update: %w", err)
+		}
+		if applied.AthleteID != athleteID {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(key, appliedUpdatePrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	dls, err := queue.NewDeadLetters(c).List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, dl := range dls {
+		if dl.Job.Event.OwnerID == athleteID {
+			ids = append(ids, dl.Job.Event.ObjectID)
+		}
+	}
+
+	keys := []string{strava.TokenKey(athleteID)}
+	for _, id := range slices.Compact(slices.Sorted(slices.Values(ids))) {
+		k, err := activityKeys(ctx, c, id)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	return keys, nil
+}
+
+// deauthorize deletes the athlete's token and data and records that they revoked access
+// so we ignore any further events for them until they authorize us again.
+func deauthorize(ctx context.Context, c cache.Cache, webhook *strava.WebhookPayload) error {
+	keys, err := athleteKeys(ctx, c, webhook.OwnerID)
+	if err != nil {
+		return err
+	}
+	if err := c.Delete(ctx, keys...); err != nil {
+		return fmt.Errorf("deleting athlete data: %w", err)
+	}
+
+	record := deauthorization{AthleteID: webhook.OwnerID, EventTime: webhook.EventTime, Time: time.Now().Unix(), Deleted: keys}
+	if err := c.SetJSON(ctx, strava.DeauthorizedKey(webhook.OwnerID), record); err != nil {
+		return fmt.Errorf("storing deauthorization: %w", err)
+	}
+
+	slog.Info("audit: athlete deauthorized", "athlete_id", record.AthleteID, "event_time", record.EventTime, "deleted", record.Deleted) //nolint:gosec // G706 noise
+	return nil
+}
+
+// deauthorized reports whether the athlete has revoked our access and not authorized us again.
+func deauthorized(ctx context.Context, c cache.Cache, athleteID int64) (bool, error) {
+	return c.Exists(ctx, strava.DeauthorizedKey(athleteID))
+}
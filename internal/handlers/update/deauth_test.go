@@ -0,0 +1,74 @@
+package update
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jarcoal/httpmock"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+func TestDeauthorization(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	setNow(t, 1000)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ot, _ := os.ReadFile("testdata/oauth_token.json")
+	httpmock.RegisterResponder("GET", `=~^https://www\.strava\.com/api/v3/activities/\d+\z`,
+		httpmock.NewStringResponder(500, ""))
+
+	r := miniredis.RunT(t)
+	defer r.Close()
+	r.Set(strava.TokenKey(1), string(ot))
+	t.Setenv("REDIS_URL", "redis://"+r.Addr())
+	// Data for the athlete's activities and another athlete's
+	r.Set(appliedUpdateKey(123), `{"athlete_id":1,"update":{"name":"Dog walk"}}`)
+	r.Set(appliedUpdateKey(456), `{"athlete_id":2,"update":{"name":"Ride"}}`)
+	r.Set(eventKey(&strava.WebhookPayload{ObjectID: 123, AspectType: "create", EventTime: 900}), "900")
+	r.Set("dead_letter:789-create-900", `{"id":"789-create-900","job":{"event":{"object_id":789,"owner_id":1,"aspect_type":"create","event_time":900}}}`)
+	r.Set(eventKey(&strava.WebhookPayload{ObjectID: 789, AspectType: "create", EventTime: 900}), "900")
+
+	tests := []struct {
+		name        string
+		webhookBody string
+		wantStatus  int
+	}{
+		{"other athlete event", `{"aspect_type": "update", "object_type": "athlete", "object_id": 1, "owner_id": 1, "updates": {"authorized": "true"}}`, http.StatusOK},
+		{"deauthorization", `{"aspect_type": "update", "object_type": "athlete", "object_id": 1, "owner_id": 1, "event_time": 1000, "updates": {"authorized": "false"}}`, http.StatusOK},
+		{"activity after deauthorization", `{"aspect_type": "create", "object_type": "activity", "object_id": 456, "owner_id": 1}`, http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tc.webhookBody))
+			rr := httptest.NewRecorder()
+			UpdateHandler(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tc.wantStatus)
+			}
+		})
+	}
+
+	if r.Exists(strava.TokenKey(1)) {
+		t.Error("expected token to be deleted")
+	}
+	if keys := r.Keys(); !slices.Equal(keys, []string{appliedUpdateKey(456), strava.DeauthorizedKey(1)}) {
+		t.Errorf("expected the athlete's activity data to be deleted, got keys %v", keys)
+	}
+	if !r.Exists(strava.DeauthorizedKey(1)) {
+		t.Error("expected deauthorization to be recorded")
+	}
+	if n := httpmock.GetTotalCallCount(); n != 0 {
+		t.Errorf("expected no Strava API calls after deauthorization, got %d", n)
+	}
+}
@@ -0,0 +1,116 @@
+package update
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/calendarevent"
+	"github.com/lildude/strautomagically/internal/client"
+	"github.com/lildude/strautomagically/internal/rules"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+// Explanation describes the changes the rules would make to an activity.
+type Explanation struct {
+	Update  *strava.UpdatableActivity `json:"update"`
+	Weather string                    `json:"weather"`
+	Msg     string                    `json:"msg"`
+	Error   string                    `json:"error,omitempty"`
+	Trace   []rules.RuleTrace         `json:"trace"`
+}
+
+// Explain returns the changes the loaded rules would make to the activity, without updating it on Strava.
+func Explain(ctx context.Context, activity *strava.Activity) *Explanation {
+	return explain(ctx, weatherClient(), activity, trainerRoadCalendar(), ruleSet)
+}
+
+func explain(ctx context.Context, wclient *client.Client, activity *strava.Activity, trcal calendarevent.CalendarEventGetter, rs *rules.RuleSet) *Explanation {
+	res, err := rs.Explain(ctx, activity, trcal)
+	if err != nil {
+		return &Explanation{Update: &strava.UpdatableActivity{}, Msg: "rules conflict", Error: err.Error(), Trace: res.Trace}
+	}
+
+	ua, msg, wtr := addWeather(ctx, wclient, activity, res)
+	return &Explanation{Update: ua, Weather: wtr, Msg: msg, Trace: res.Trace}
+}
+
+// FetchActivity gets the athlete's activity, and any laps and streams the loaded rules
+// need, from Strava using their cached token.
+func FetchActivity(ctx context.Context, athleteID, id int64) (*strava.Activity, error) {
+	rcache, err := cache.Open(ctx, cache.URL())
+	if err != nil {
+		return nil, fmt.Errorf("opening cache: %w", err)
+	}
+
+	sc, err := strava.NewClient(ctx, rcache, athleteID)
+	if err != nil {
+		return nil, err
+	}
+
+	return getActivity(ctx, sc, id)
+}
+
+// ExplainHandler returns the changes the rules would make to an activity without updating it.
+// The activity is fetched from Strava if id and athlete query parameters are given, otherwise
+// the activity JSON is read from the request body. Requests must include the ADMIN_TOKEN as a bearer token.
+func ExplainHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var activity *strava.Activity
+	if id := r.URL.Query().Get("id"); id != "" {
+		aid, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid activity id", http.StatusBadRequest)
+			return
+		}
+		athleteID, err := strconv.ParseInt(r.URL.Query().Get("athlete"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid athlete id", http.StatusBadRequest)
+			return
+		}
+		activity, err = FetchActivity(r.Context(), athleteID, aid)
+		if err != nil {
+			slog.Error("unable to get activity", "error", sanitizeForLog(err.Error()))
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+	} else {
+		if r.Body == nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &activity); err != nil || activity == nil {
+			http.Error(w, "invalid activity JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(Explain(r.Context(), activity)); err != nil {
+		slog.Error("encoding explanation", "error", err)
+	}
+}
+
+// authorized reports whether the request has the ADMIN_TOKEN as a bearer token.
+// No requests are authorized if ADMIN_TOKEN isn't set.
+func authorized(r *http.Request) bool {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return adminToken != "" && ok && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
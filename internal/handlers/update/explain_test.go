@@ -0,0 +1,91 @@
+package update
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lildude/strautomagically/internal/rules"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+func TestExplainHandler(t *testing.T) {
+	// Leave the weather out so the explanation only depends on the rules
+	setupUpdateTest(t, "virtualride.json", `
+rules:
+  - name: Virtual ride
+    match:
+      type: VirtualRide
+    set:
+      gear_id: b9880609
+      trainer: true
+      weather: false
+  - name: Run
+    match:
+      type: Run
+    set:
+      hide_from_home: true
+`)
+	t.Setenv("ADMIN_TOKEN", "admin")
+	activity, _ := os.ReadFile("testdata/virtualride.json")
+
+	virtualRide := &strava.UpdatableActivity{
+		GearID:  "b9880609",
+		Trainer: strava.Bool(true),
+	}
+	wantTrace := []rules.RuleTrace{
+		{Rule: "Virtual ride", Matched: true, Applied: true, Conditions: []rules.Condition{{Condition: `type in ["VirtualRide"]`, Value: "VirtualRide", Matched: true}}, Changes: []string{`gear_id: "b9880609"`, "trainer: true", "weather: false"}},
+		{Rule: "Run", Conditions: []rules.Condition{{Condition: `type in ["Run"]`, Value: "VirtualRide"}}},
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		query      string
+		body       string
+		token      string
+		wantStatus int
+		want       *strava.UpdatableActivity
+	}{
+		{"no token", http.MethodPost, "", string(activity), "", http.StatusNotFound, nil},
+		{"wrong token", http.MethodPost, "", string(activity), "wrong", http.StatusNotFound, nil},
+		{"invalid JSON", http.MethodPost, "", `{"foo: "bar"}`, "admin", http.StatusBadRequest, nil},
+		{"invalid id", http.MethodGet, "?id=abc", "", "admin", http.StatusBadRequest, nil},
+		{"activity JSON", http.MethodPost, "", string(activity), "admin", http.StatusOK, virtualRide},
+		{"missing athlete id", http.MethodGet, "?id=123", "", "admin", http.StatusBadRequest, nil},
+		{"activity id", http.MethodGet, "?id=123&athlete=1", "", "admin", http.StatusOK, virtualRide},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/explain"+tc.query, strings.NewReader(tc.body))
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rr := httptest.NewRecorder()
+			ExplainHandler(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %d want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.want == nil {
+				return
+			}
+
+			var got Explanation
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unexpected error decoding response: %v", err)
+			}
+			if !reflect.DeepEqual(got.Update, tc.want) {
+				t.Errorf("expected update %+v, got %+v", tc.want, got.Update)
+			}
+			if !reflect.DeepEqual(got.Trace, wantTrace) {
+				t.Errorf("expected trace %+v, got %+v", wantTrace, got.Trace)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package update
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+// eventTTL is how long processed events are remembered. Strava retries events
+// it doesn't get a response to within a few minutes so this is plenty.
+const eventTTL = 24 * time.Hour
+
+// eventKey returns the cache key recording that the event has been processed.
+// Events are identified by the object, the aspect type and when the event happened.
+func eventKey(webhook *strava.WebhookPayload) string {
+	return fmt.Sprintf("%s%s:%d", activityEventPrefix(webhook.ObjectID), webhook.AspectType, webhook.EventTime)
+}
+
+// activityEventPrefix returns the prefix of the cache keys of the events for the object.
+func activityEventPrefix(id int64) string {
+	return fmt.Sprintf("strava_event:%d:", id)
+}
+
+// claimEvent records that the event is being processed, reporting false if it already has been.
+// The check and the write are a single atomic operation so only one of any concurrent
+// deliveries of the same event is processed.
+func claimEvent(ctx context.Context, c cache.Cache, webhook *strava.WebhookPayload) (bool, error) {
+	claimed, err := c.SetNX(ctx, eventKey(webhook), time.Now().Unix(), eventTTL)
+	if err != nil {
+		return false, fmt.Errorf("recording event: %w", err)
+	}
+	return claimed, nil
+}
+
+// forgetEvent forgets the event so it's processed if it's delivered again.
+func forgetEvent(ctx context.Context, c cache.Cache, webhook *strava.WebhookPayload) {
+	if err := c.Delete(context.WithoutCancel(ctx), eventKey(webhook)); err != nil {
+		slog.Error("unable to forget event", "error", err)
+	}
+}
+
+// failuresKey returns the cache key counting the deliveries of the event we failed to process.
+func failuresKey(webhook *strava.WebhookPayload) string {
+	return eventKey(webhook) + ":failures"
+}
+
+// recordFailure counts a failed delivery of the event, returning how many there have been.
+func recordFailure(ctx context.Context, c cache.Cache, webhook *strava.WebhookPayload) (int, error) {
+	v, err := c.Get(ctx, failuresKey(webhook))
+	if err != nil {
+		return 0, fmt.Errorf("getting event failures: %w", err)
+	}
+	s, _ := v.(string)
+	failures, _ := strconv.Atoi(s)
+	failures++
+	if err := c.SetEX(ctx, failuresKey(webhook), failures, eventTTL); err != nil {
+		return failures, fmt.Errorf("recording event failure: %w", err)
+	}
+	return failures, nil
+}
@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
@@ -12,18 +14,44 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
-	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/lildude/strautomagically/internal/cache"
 	"github.com/lildude/strautomagically/internal/calendarevent"
 	"github.com/lildude/strautomagically/internal/client"
+	"github.com/lildude/strautomagically/internal/queue"
+	"github.com/lildude/strautomagically/internal/rules"
 	"github.com/lildude/strautomagically/internal/strava"
 	"github.com/lildude/strautomagically/internal/weather"
-	"golang.org/x/oauth2"
 )
 
+// aspectDelete is the aspect type of events for deleted activities.
+const aspectDelete = "delete"
+
+// ruleSet holds the rules loaded at startup by LoadRules.
+var ruleSet *rules.RuleSet
+
+// jobs is the queue webhook events are added to by UpdateHandler. Events are processed
+// before responding if it's nil.
+var jobs queue.Queue
+
+// SetQueue sets the queue webhook events are added to for processing by ProcessJob.
+func SetQueue(q queue.Queue) {
+	jobs = q
+}
+
+// LoadRules loads the rules file used to update activities.
+func LoadRules(path string) error {
+	rs, err := rules.Load(path)
+	if err != nil {
+		return err
+	}
+	ruleSet = rs
+	return nil
+}
+
 func UpdateHandler(w http.ResponseWriter, r *http.Request) {
 	var webhook strava.WebhookPayload
 	if r.Body == nil {
@@ -38,255 +66,457 @@ func UpdateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// We only react to new activities for now
-	if webhook.AspectType != "create" {
+	reason, err := checkRequest(r, &webhook)
+	if err != nil {
+		slog.Error("unable to check webhook request", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if reason == rejectSourceIP {
+		rejectEvent(r, &webhook, reason)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if reason != "" {
+		// Respond OK as Strava would only retry the event
+		rejectEvent(r, &webhook, reason)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// We react to athletes revoking our access, deleted activities, new activities, and
+	// changed activities if any rules ask for them
+	switch {
+	case webhook.ObjectType == "athlete":
+		if webhook.Updates.Authorized != "false" {
+			w.WriteHeader(http.StatusOK)
+			slog.Info("ignoring athlete webhook")
+			return
+		}
+	case webhook.AspectType == aspectDelete:
+	case webhook.AspectType == rules.EventCreate:
+	case webhook.AspectType == rules.EventUpdate && ruleSet.HandlesUpdates():
+	default:
 		w.WriteHeader(http.StatusOK)
-		slog.Info("ignoring non-create webhook")
+		slog.Info("ignoring webhook", "aspect_type", sanitizeForLog(webhook.AspectType))
 		return
 	}
 
-	rcache, err := cache.NewRedisCache(r.Context(), os.Getenv("REDIS_URL"))
+	rcache, err := cache.Open(r.Context(), cache.URL())
 	if err != nil {
-		slog.Error("unable to create redis cache", "error", err)
+		slog.Error("unable to open cache", "error", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	// See if we've seen this activity before
-	aid, err := rcache.Get(r.Context(), "strava_activity")
+	revoked, err := deauthorized(r.Context(), rcache, webhook.OwnerID)
 	if err != nil {
-		slog.Error("unable to get activity id from cache", "error", err)
+		slog.Error("unable to get athlete authorization from cache", "error", err)
 	}
-	// Convert aid to int
-	s, _ := aid.(string)
-	aidInt, _ := strconv.ParseInt(s, 10, 64)
-
-	if os.Getenv("ENV") != "dev" && aidInt == webhook.ObjectID {
+	if revoked {
 		w.WriteHeader(http.StatusOK)
-		slog.Info("ignoring repeat event")
+		slog.Info("ignoring event for deauthorized athlete", "athlete_id", webhook.OwnerID)
 		return
 	}
 
-	// Create the OAuth http.Client
-	authToken := &oauth2.Token{}
-	err = rcache.GetJSON(r.Context(), "strava_auth_token", &authToken)
+	reason, err = checkEvent(r.Context(), rcache, &webhook)
 	if err != nil {
-		slog.Error("unable to get token", "error", err)
+		slog.Error("unable to check webhook event", "error", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	if reason != "" {
+		// Unknown athletes need to authorize at /auth
+		rejectEvent(r, &webhook, reason)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-	// The Oauth2 library handles refreshing the token if it's expired.
-	ts := strava.OauthConfig.TokenSource(r.Context(), authToken)
-	tc := oauth2.NewClient(r.Context(), ts)
-	surl, _ := url.Parse(strava.BaseURL)
+	// Athlete events tell us when an athlete revokes our access
+	if webhook.ObjectType == "athlete" {
+		athleteHandler(w, r, rcache, &webhook)
+		return
+	}
 
-	newToken, err := ts.Token()
-	if err != nil {
-		slog.Error("unable to refresh token", "error", err)
+	// Deleted activities only need their data removing
+	if webhook.AspectType == aspectDelete {
+		deleteHandler(w, r, rcache, &webhook)
 		return
 	}
-	if newToken.AccessToken != authToken.AccessToken {
-		err = rcache.SetJSON(r.Context(), "strava_auth_token", newToken)
+
+	// Ignore events we've already processed, eg when Strava retries an event we were slow to respond to
+	if os.Getenv("ENV") != "dev" {
+		claimed, err := claimEvent(r.Context(), rcache, &webhook)
 		if err != nil {
-			slog.Error("unable to store token", "error", err)
+			slog.Error("unable to record event", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !claimed {
+			w.WriteHeader(http.StatusOK)
+			slog.Info("ignoring repeat event", "id", webhook.ObjectID, "aspect_type", webhook.AspectType)
 			return
 		}
-		slog.Info("updated token")
 	}
 
-	sc := client.NewClient(surl, tc)
+	// Strava expects a response within two seconds so queue the event to be processed in the background
+	if jobs != nil {
+		if err := jobs.Enqueue(r.Context(), &queue.Job{Event: webhook, EnqueuedAt: time.Now()}); err != nil {
+			slog.Error("unable to queue event", "error", err)
+			forgetEvent(r.Context(), rcache, &webhook)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("event queued", "id", webhook.ObjectID, "aspect_type", webhook.AspectType)
 
-	activity, err := strava.GetActivity(r.Context(), sc, webhook.ObjectID)
-	if err != nil {
-		slog.Error("unable to get activity", "error", sanitizeForLog(err.Error()))
+		w.WriteHeader(http.StatusOK)
+		if _, err = w.Write([]byte(`queued`)); err != nil {
+			slog.Error("write failed", "error", err)
+		}
+		return
+	}
+
+	if err := processEvent(r.Context(), rcache, &webhook); err != nil {
+		slog.Error("unable to process event", "error", sanitizeForLog(err.Error())) //nolint:gosec // G706 noise
+		if giveUp(r.Context(), rcache, &webhook, err) {
+			// The event is kept as a dead letter so Strava doesn't need to deliver it again
+			w.WriteHeader(http.StatusOK)
+			if _, err = w.Write([]byte(`failed`)); err != nil {
+				slog.Error("write failed", "error", err)
+			}
+			return
+		}
+		forgetEvent(r.Context(), rcache, &webhook)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write([]byte(`success`)); err != nil {
+		slog.Error("write failed", "error", err)
+	}
+}
+
+// maxDeliveries is the number of times Strava delivers an event that isn't processed
+// successfully. It's the equivalent of the pool's attempts when there's no queue.
+const maxDeliveries = 3
+
+// giveUp stores the event as a dead letter, like the pool does with failed jobs, if
+// processing it without a queue failed on Strava's last delivery or because the rate
+// limit is used up, and reports whether it did. There's no worker to wait for the rate
+// limit to reset so those events are kept to be replayed once it has.
+func giveUp(ctx context.Context, c cache.Cache, webhook *strava.WebhookPayload, err error) bool {
+	job := &queue.Job{Event: *webhook, EnqueuedAt: time.Now()}
+
+	var rle *client.RateLimitError
+	if errors.As(err, &rle) {
+		slog.Warn("rate limited, storing event to replay", "id", webhook.ObjectID, "until", rle.Reset)
+	} else {
+		failures, ferr := recordFailure(context.WithoutCancel(ctx), c, webhook)
+		if ferr != nil {
+			slog.Error("unable to record event failure", "error", ferr)
+		}
+		if failures < maxDeliveries {
+			return false
+		}
+		job.Attempts = failures
+		slog.Error("event failed, giving up", "id", webhook.ObjectID, "attempts", failures)
+	}
+
+	StoreDeadLetter(context.WithoutCancel(ctx), job, err)
+	return true
+}
+
+// ProcessJob processes a queued webhook event.
+func ProcessJob(ctx context.Context, job *queue.Job) error {
+	rcache, err := cache.Open(ctx, cache.URL())
+	if err != nil {
+		return fmt.Errorf("opening cache: %w", err)
+	}
+	return processEvent(ctx, rcache, &job.Event)
+}
+
+// getActivity gets the activity along with any laps and streams the rules need.
+func getActivity(ctx context.Context, sc *client.Client, id int64) (*strava.Activity, error) {
+	activity, err := strava.GetActivity(ctx, sc, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := strava.GetDetails(ctx, sc, activity, ruleSet.Needs(rules.DetailLaps), ruleSet.Needs(rules.DetailStreams)); err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+// processEvent updates the activity in the create or update event using the rules.
+func processEvent(ctx context.Context, c cache.Cache, webhook *strava.WebhookPayload) error {
+	var last *appliedUpdate
+	if webhook.AspectType == rules.EventUpdate {
+		var err error
+		last, err = lastUpdate(ctx, c, webhook.ObjectID)
+		if err != nil {
+			slog.Error("unable to get last update from cache", "error", err)
+		}
+
+		// Updating an activity triggers an update event so don't react to our own updates
+		if ownUpdate(webhook, last) {
+			slog.Info("ignoring update event caused by our update", "id", webhook.ObjectID)
+			return nil
+		}
+	}
+
+	sc, err := strava.NewClient(ctx, c, webhook.OwnerID)
+	if err != nil {
+		return fmt.Errorf("creating strava client: %w", err)
+	}
+
+	activity, err := getActivity(ctx, sc, webhook.ObjectID)
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.NotFound() {
+		// The activity was deleted or made private before we got to it so there's nothing to update
+		slog.Info("activity not found, ignoring event", "id", webhook.ObjectID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting activity: %w", err)
+	}
+
 	slog.Info("activity received", "name", activity.Name, "id", activity.ID)
 
-	baseURL := &url.URL{Scheme: "https", Host: "api.openweathermap.org", Path: "/data/3.0/onecall"}
-	wclient := client.NewClient(baseURL, nil)
-	trcal := calendarevent.NewCalendarService(http.DefaultClient, "https://api.trainerroad.com/v1/calendar/ics", os.Getenv("TRAINERROAD_CAL_ID"))
-	update, msg := constructUpdate(r.Context(), wclient, activity, trcal)
+	ev := rules.Event{AspectType: webhook.AspectType, Updates: webhook.Updates}
+	update, rulesApplied, msg := constructUpdate(ctx, weatherClient(), activity, ev, trainerRoadCalendar(), ruleSet)
 
 	// Don't update the activity if DEBUG=1
 	if os.Getenv("DEBUG") == "1" {
 		slog.Debug("update", "update", update) //nolint:gosec // G706 noise
 		slog.Debug("message", "msg", msg)      //nolint:gosec // G706 noise
-		return
+		return nil
 	}
 
-	if !reflect.DeepEqual(update, strava.UpdatableActivity{}) {
-		var updated *strava.Activity
-		updated, err = strava.UpdateActivity(r.Context(), sc, webhook.ObjectID, update)
-		if err != nil {
-			slog.Error("unable to update activity", "error", sanitizeForLog(err.Error())) //nolint:gosec // G706 noise
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
+	// Rules applied on update events make the same changes again unless something else has changed
+	if ev.AspectType == rules.EventUpdate && last != nil && reflect.DeepEqual(*update, last.Update) {
+		slog.Info("ignoring update event, no new changes", "id", webhook.ObjectID)
+		return nil
+	}
+
+	if reflect.DeepEqual(update, &strava.UpdatableActivity{}) {
+		return nil
+	}
 
-		slog.Info("activity updated", "name", updated.Name, "id", updated.ID, "msg", msg) //nolint:gosec // G706 noise
+	// Record the update before making it as Strava may send the update event before we're done
+	applied := appliedUpdate{AthleteID: webhook.OwnerID, Update: *update, Rules: rulesApplied, Time: time.Now().Unix()}
+	if err := c.SetJSON(ctx, appliedUpdateKey(webhook.ObjectID), applied); err != nil {
+		slog.Error("unable to cache activity update", "error", err)
+	}
 
-		// Cache activity ID if we've succeeded
-		err = rcache.Set(r.Context(), "strava_activity", webhook.ObjectID)
-		if err != nil {
-			slog.Error("unable to cache activity id", "error", err)
+	updated, err := strava.UpdateActivity(ctx, sc, webhook.ObjectID, update)
+	if err != nil {
+		// Put the record of the last update back so a retry isn't mistaken for a repeat
+		if last != nil {
+			err = errors.Join(err, c.SetJSON(ctx, appliedUpdateKey(webhook.ObjectID), last))
+		} else {
+			err = errors.Join(err, c.Delete(ctx, appliedUpdateKey(webhook.ObjectID)))
 		}
+		return fmt.Errorf("updating activity: %w", err)
+	}
+
+	slog.Info("activity updated", "name", updated.Name, "id", updated.ID, "msg", msg) //nolint:gosec // G706 noise
+	return nil
+}
+
+// athleteHandler handles athlete deauthorization events.
+func athleteHandler(w http.ResponseWriter, r *http.Request, rcache cache.Cache, webhook *strava.WebhookPayload) {
+	if err := deauthorize(r.Context(), rcache, webhook); err != nil {
+		slog.Error("unable to deauthorize athlete", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	if _, err = w.Write([]byte(`success`)); err != nil {
+	if _, err := w.Write([]byte(`success`)); err != nil {
 		slog.Error("write failed", "error", err)
 	}
 }
 
-func constructUpdate(ctx context.Context, wclient *client.Client, activity *strava.Activity, trcal *calendarevent.CalendarService) (ua *strava.UpdatableActivity, msg string) {
-	var update strava.UpdatableActivity
-	var title string
-	msg = "no activity changes"
-	const trainer = "b9880609" // Tacx Neo 2T Turbo
-	const bike = "b10013574"   // Dolan Tuono Disc
-	const shoes = "g10043849"  // No name, Not running shoes
-
-	// TODO: Move these to somewhere more configurable
-	switch activity.Type {
-	// I'll never handcycle. This is used for testing only
-	case "Handcycle":
-		return &update, msg
-
-	case "Ride":
-		title = activity.Name
-
-		if strings.HasPrefix(activity.ExternalID, "trainerroad") {
-			update.GearID = trainer
-			update.Trainer = true
-
-			// Get the name from TrainerRoad calendar
-			// We assume we've already done this if the activity name starts with TR
-			if !strings.HasPrefix(activity.Name, "TR: ") {
-				event, err := trcal.GetCalendarEvent(ctx, activity.StartDate)
-				if err != nil {
-					slog.Error("unable to get TrainerRoad calendar event", "error", err)
-				}
-
-				if event != nil && event.Summary != "" {
-					slog.Info("found TrainerRoad calendar event", "summary", event.Summary) //nolint:gosec // G706 noise
-					title = "TR: " + event.Summary
-				} else {
-					slog.Info("no TrainerRoad calendar event found")
-				}
-			}
-		} else {
-			update.GearID = bike
-		}
+// deleteHandler removes everything we've stored about a deleted activity.
+func deleteHandler(w http.ResponseWriter, r *http.Request, rcache cache.Cache, webhook *strava.WebhookPayload) {
+	if err := purgeActivity(r.Context(), rcache, webhook.ObjectID); err != nil {
+		slog.Error("unable to purge activity", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("activity deleted", "id", webhook.ObjectID)
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(`success`)); err != nil {
+		slog.Error("write failed", "error", err)
+	}
+}
 
-		if title != activity.Name {
-			update.Name = title
+// activityKeys returns the cache keys holding data for the activity: the last update
+// made to it, the events we've processed for it and its dead letters, so they can't be
+// replayed once it's gone.
+func activityKeys(ctx context.Context, c cache.Cache, id int64) ([]string, error) {
+	keys := []string{appliedUpdateKey(id)}
+	for _, prefix := range []string{activityEventPrefix(id), queue.ActivityPrefix(id)} {
+		k, err := c.Keys(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("listing activity data: %w", err)
 		}
+		keys = append(keys, k...)
+	}
+	return keys, nil
+}
 
-		msg = "prefixed name of ride with TR and set gear"
+// purgeActivity deletes the data stored for the activity.
+func purgeActivity(ctx context.Context, c cache.Cache, id int64) error {
+	keys, err := activityKeys(ctx, c, id)
+	if err != nil {
+		return err
+	}
+	if err := c.Delete(ctx, keys...); err != nil {
+		return fmt.Errorf("deleting activity data: %w", err)
+	}
+	return nil
+}
 
-	case "Rowing":
-		// Workouts created in ErgZone will have the name in the first line of the description
-		lines := strings.Split(activity.Description, "\n")
-		if len(lines) > 0 {
-			// We only want the first line if the description contains the app.erg.zone URL
-			if strings.Contains(activity.Description, "app.erg.zone") {
-				title = lines[0]
-				update.Description = "\n"
-			}
-		}
+// ownUpdateWindow is how long after we update an activity that an update event
+// without any changes we don't know about is assumed to be caused by our update.
+const ownUpdateWindow = 10 * time.Minute
+
+// appliedUpdate records the last update made to an activity.
+type appliedUpdate struct {
+	// AthleteID is the athlete the activity belongs to, so it can be deleted if they revoke our access.
+	AthleteID int64                    `json:"athlete_id"`
+	Update    strava.UpdatableActivity `json:"update"`
+	Rules     []string                 `json:"rules"`
+	// Time is when the update was made as a Unix timestamp.
+	Time int64 `json:"time"`
+}
 
-		// Fallback to the name if there is no description or it doesn't contain "app.erg.zone"
-		if title == "" {
-			switch activity.Name {
-			case "v250m/1:30r...7 row", "v5:00/1:00r...15 row":
-				title = "Speed Pyramid Row w/ 1.5' Active RI per 250m work"
-			case "8x500m/3:30r row", "v5:00/1:00r...17 row":
-				title = "8x 500m w/ 3.5' Active RI Row"
-			case "5x1500m/5:00r row":
-				title = "5x 1500m w/ 5' RI Row"
-			case "4x2000m/5:00r row", "v5:00/1:00r...9 row":
-				title = "4x 2000m w/5' Active RI Row"
-			case "4x1000m/5:00r row":
-				title = "4x 1000m /5' RI Row"
-			case "v3000m/5:00r...3 row", "v5:00/1:00r...7 row":
-				title = "Waterfall of 3k, 2.5k, 2k w/ 5' Active RI Row"
-			case "5:00 row":
-				title = "Warm-up Row"
-				update.HideFromHome = true
-			}
-		}
-		update.Name = title
-		if title != "" {
-			msg = "set title to " + title
-		}
+// appliedUpdatePrefix is the prefix of the cache keys of the last updates made to activities.
+const appliedUpdatePrefix = "strava_activity_update:"
 
-	// Nothing to change here yet
-	// case "Run":
-	// 	return &update, msg
-
-	case "VirtualRide":
-		// Set gear to trainer
-		update.GearID = trainer
-		update.Trainer = true
-		msg = "set gear to trainer"
-	case "Walk":
-		// Check if it's an early morning dog walk (before 9am and at least 20 minutes)
-		hour := activity.StartDateLocal.Hour()
-		if hour < 9 && activity.ElapsedTime >= 1200 {
-			update.Name = "Emptying & Exercising the 🐶"
-			update.Private = false
-			update.GearID = shoes
-			update.WithPet = true
-			msg = "set dog walking title and made public"
-		} else {
-			// Mute walks and set shoes
-			update.HideFromHome = true
-			update.GearID = shoes
-			msg = "muted walk"
-		}
-	case "WeightTraining":
-		// Set Humane Burpees Title for WeightLifting activities between 3 & 7 minutes long
-		if activity.ElapsedTime >= 180 && activity.ElapsedTime <= 420 {
-			update.HideFromHome = true
-			update.Name = "Humane Burpees"
-			msg = "set humane burpees title"
-		}
+// appliedUpdateKey returns the cache key for the last update made to the activity.
+func appliedUpdateKey(id int64) string {
+	return fmt.Sprintf("%s%d", appliedUpdatePrefix, id)
+}
+
+// lastUpdate returns the last update made to the activity, or nil if it's never been updated.
+func lastUpdate(ctx context.Context, c cache.Cache, id int64) (*appliedUpdate, error) {
+	v, err := c.Get(ctx, appliedUpdateKey(id))
+	if err != nil {
+		return nil, err
+	}
+	if s, _ := v.(string); s == "" {
+		return nil, nil
+	}
+
+	var last appliedUpdate
+	if err := c.GetJSON(ctx, appliedUpdateKey(id), &last); err != nil {
+		return nil, err
+	}
+	return &last, nil
+}
+
+// ownUpdate reports whether the update event was caused by our last update to the activity.
+// This is the case if every change in the event was made by our update and the event was
+// soon after our update. Strava only tells us about changes to the title, type and privacy.
+func ownUpdate(webhook *strava.WebhookPayload, last *appliedUpdate) bool {
+	if last == nil || webhook.EventTime-last.Time > int64(ownUpdateWindow.Seconds()) {
+		return false
+	}
+
+	u := webhook.Updates
+	if u.Title != "" && u.Title != last.Update.Name {
+		return false
+	}
+	if u.Type != "" && u.Type != last.Update.Type && u.Type != last.Update.SportType {
+		return false
+	}
+	// We only change the privacy if we make an activity private
+	if u.Private != "" && (u.Private != "true" || last.Update.Private == nil || !*last.Update.Private) {
+		return false
+	}
+	return true
+}
+
+// knownAthlete reports whether we have a token for the athlete.
+func knownAthlete(ctx context.Context, c cache.Cache, athleteID int64) (bool, error) {
+	return c.Exists(ctx, strava.TokenKey(athleteID))
+}
+
+// weatherClient returns an OpenWeather API client.
+func weatherClient() *client.Client {
+	baseURL := &url.URL{Scheme: "https", Host: "api.openweathermap.org", Path: "/data/3.0/onecall"}
+	c := client.NewClient(baseURL, nil)
+	c.Retry = &client.RetryPolicy{}
+	return c
+}
+
+// trainerRoadCalendar returns the TrainerRoad calendar used to name TrainerRoad activities.
+func trainerRoadCalendar() *calendarevent.CalendarService {
+	return calendarevent.NewCalendarService(http.DefaultClient, "https://api.trainerroad.com/v1/calendar/ics", os.Getenv("TRAINERROAD_CAL_ID"))
+}
+
+// constructUpdate returns the update to make to the activity for the webhook event, the
+// names of the rules that were applied and a summary of the changes.
+func constructUpdate(ctx context.Context, wclient *client.Client, activity *strava.Activity, ev rules.Event, trcal *calendarevent.CalendarService, rs *rules.RuleSet) (ua *strava.UpdatableActivity, applied []string, msg string) {
+	res, err := rs.ApplyEvent(ctx, activity, ev, trcal)
+	if err != nil {
+		slog.Error("unable to apply rules", "error", err)
+		return &strava.UpdatableActivity{}, nil, "rules conflict: " + err.Error()
+	}
+
+	ua, msg, _ = addWeather(ctx, wclient, activity, res)
+	return ua, res.Rules, msg
+}
+
+// addWeather returns the update from the rules with the weather added to the description, if
+// the rules allow it, along with a summary of the changes and the weather line that was added.
+func addWeather(ctx context.Context, wclient *client.Client, activity *strava.Activity, res *rules.Result) (ua *strava.UpdatableActivity, msg, wtr string) {
+	update := res.Update
+	msg = "no activity changes"
+	if len(res.Rules) > 0 {
+		msg = "applied rules: " + strings.Join(res.Rules, ", ")
+	}
+
+	if !res.Weather {
+		return &update, msg, ""
 	}
 
 	// Do nothing if we've already got weather data
 	if strings.Contains(activity.Description, "AQI") {
-		return &update, msg
+		return &update, msg, ""
 	}
 
 	painCave, lat, lon := true, float64(0), float64(0)
-	if len(activity.StartLatlng) > 0 && activity.Type != "VirtualRide" {
+	if len(activity.StartLatlng) > 0 && activity.Sport() != "VirtualRide" {
 		painCave, lat, lon = false, activity.StartLatlng[0], activity.StartLatlng[1]
 	}
 
 	w, _ := weather.GetWeatherLine(ctx, wclient, activity.StartDateLocal, activity.ElapsedTime, lat, lon)
-	if painCave {
-		// Put lat and lon back to 0 for easier templating
-		w.Start.Lat, w.Start.Lon, w.End.Lat, w.End.Lon = 0, 0, 0, 0
-	}
-
 	if w != nil {
-		wtr, err := execTemplate("weather.tmpl", w)
+		if painCave {
+			// Put lat and lon back to 0 for easier templating
+			w.Start.Lat, w.Start.Lon, w.End.Lat, w.End.Lon = 0, 0, 0, 0
+		}
+
+		var err error
+		wtr, err = execTemplate("weather.tmpl", w)
 		if err != nil {
 			slog.Error("unable to parse weather template", "error", err)
 		}
 
-		if activity.Description != "" && update.Description != "\n" {
+		// Rules that set the description replace the original rather than adding to it
+		if activity.Description != "" && update.Description == "" {
 			update.Description = activity.Description + "\n\n"
 		}
 		update.Description += wtr
 		msg += " & added weather"
 	}
 
-	return &update, msg
+	return &update, msg, wtr
 }
 
 // sanitizeForLog removes newline characters from a string to prevent log injection (CWE-117).
@@ -18,12 +18,15 @@ import (
 	"github.com/jarcoal/httpmock"
 	"github.com/lildude/strautomagically/internal/calendarevent"
 	"github.com/lildude/strautomagically/internal/client"
+	"github.com/lildude/strautomagically/internal/queue"
+	"github.com/lildude/strautomagically/internal/rules"
 	"github.com/lildude/strautomagically/internal/strava"
 )
 
 func TestUpdateHandler(t *testing.T) {
 	// Discard logs to avoid polluting test output
 	slog.SetDefault(slog.New(slog.DiscardHandler))
+	setNow(t, 1000)
 
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -52,7 +55,7 @@ func TestUpdateHandler(t *testing.T) {
 	tests := []struct {
 		name        string
 		webhookBody string
-		redis       []string // Used to seed Redis with the expected values for the tests
+		redis       []string // Used to seed Redis with the token and, if the event has been processed, when it was processed
 		wantStatus  int
 	}{
 		{
@@ -75,19 +78,25 @@ func TestUpdateHandler(t *testing.T) {
 		},
 		{
 			"unresponsive redis",
-			`{"aspect_type": "create", "object_id": 123}`,
+			`{"aspect_type": "create", "object_id": 123, "owner_id": 1}`,
 			[]string{"", ""},
 			500,
 		},
 		{
 			"repeat event",
-			`{"aspect_type": "create", "object_id": 123}`,
-			[]string{token, "123"},
+			`{"aspect_type": "create", "object_id": 123, "owner_id": 1, "event_time": 1000}`,
+			[]string{token, "1000"},
 			200,
 		},
 		{
 			"create event",
-			`{"aspect_type": "create", "object_id": 456}`,
+			`{"aspect_type": "create", "object_id": 456, "owner_id": 1}`,
+			[]string{token, ""},
+			200,
+		},
+		{
+			"unknown athlete",
+			`{"aspect_type": "create", "object_id": 789, "owner_id": 2}`,
 			[]string{token, ""},
 			200,
 		},
@@ -101,8 +110,13 @@ func TestUpdateHandler(t *testing.T) {
 			// Pre-populate Redis with the expected values, if set, and set REDIS_URL to use the miniredis instance
 			if tc.redis[0] != "" {
 				t.Setenv("REDIS_URL", "redis://"+r.Addr())
-				r.Set("strava_auth_token", tc.redis[0])
-				r.Set("strava_activity", tc.redis[1])
+				r.Set(strava.TokenKey(1), tc.redis[0])
+				if tc.redis[1] != "" {
+					// Record the event as already processed
+					var webhook strava.WebhookPayload
+					json.Unmarshal([]byte(tc.webhookBody), &webhook)
+					r.Set(eventKey(&webhook), tc.redis[1])
+				}
 			} else {
 				t.Setenv("REDIS_URL", "foobar") // Forces a quick failure mimicking a non-existent Redis instance
 			}
@@ -123,6 +137,360 @@ func TestUpdateHandler(t *testing.T) {
 	}
 }
 
+func TestUpdateEvents(t *testing.T) {
+	setupUpdateTest(t, "activity.json", `
+rules:
+  - name: Commute
+    on: update
+    when: updates.title contains "commute"
+    set:
+      commute: true
+      weather: false
+`)
+
+	const put = `PUT =~^https://www\.strava\.com/api/v3/activities/\d+\z`
+	tests := []struct {
+		name        string
+		webhookBody string
+		wantUpdate  bool
+	}{
+		{
+			"renamed activity",
+			`{"aspect_type": "update", "object_type": "activity", "object_id": 123, "owner_id": 1, "event_time": 1000, "updates": {"title": "My commute"}}`,
+			true,
+		},
+		{
+			"same changes again",
+			`{"aspect_type": "update", "object_type": "activity", "object_id": 123, "owner_id": 1, "event_time": 1100, "updates": {"title": "My commute home"}}`,
+			false,
+		},
+		{
+			"non-matching update",
+			`{"aspect_type": "update", "object_type": "activity", "object_id": 456, "owner_id": 1, "event_time": 1000, "updates": {"title": "Ride"}}`,
+			false,
+		},
+		{
+			"athlete event",
+			`{"aspect_type": "update", "object_type": "athlete", "object_id": 123, "updates": {"authorized": "false"}}`,
+			false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			httpmock.ZeroCallCounters()
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tc.webhookBody))
+			rr := httptest.NewRecorder()
+			UpdateHandler(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+			}
+			if got := httpmock.GetCallCountInfo()[put] == 1; got != tc.wantUpdate {
+				t.Errorf("expected activity update %v, got %v", tc.wantUpdate, got)
+			}
+		})
+	}
+}
+
+func TestDetailsEvents(t *testing.T) {
+	setupUpdateTest(t, "activity.json", `
+rules:
+  - name: Hard
+    when: max_heartrate > 170
+    set:
+      name: Hard ride
+      weather: false
+  - name: No heart rate
+    when: max_heartrate == 0
+    set:
+      name: Manual ride
+      weather: false
+`)
+
+	const put = `PUT =~^https://www\.strava\.com/api/v3/activities/\d+\z`
+	const streams = "GET https://www.strava.com/api/v3/activities/123/streams"
+	tests := []struct {
+		name          string
+		body          string
+		streamsStatus int
+		streamsBody   string
+		wantUpdate    bool
+	}{
+		{
+			"max heart rate matches",
+			`{"aspect_type": "create", "object_type": "activity", "object_id": 123, "owner_id": 1, "event_time": 1000}`,
+			200,
+			`{"heartrate": {"data": [120, 176, 150]}}`,
+			true,
+		},
+		{
+			"max heart rate too low",
+			`{"aspect_type": "create", "object_type": "activity", "object_id": 123, "owner_id": 1, "event_time": 1001}`,
+			200,
+			`{"heartrate": {"data": [110, 130, 125]}}`,
+			false,
+		},
+		{
+			// Manual activities don't have streams but should still be updated
+			"manual activity without streams",
+			`{"aspect_type": "create", "object_type": "activity", "object_id": 123, "owner_id": 1, "event_time": 1002}`,
+			404,
+			`{"message": "Record Not Found", "errors": [{"resource": "Activity", "field": "", "code": "not found"}]}`,
+			true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			httpmock.RegisterResponder("GET", "https://www.strava.com/api/v3/activities/123/streams",
+				httpmock.NewStringResponder(tc.streamsStatus, tc.streamsBody))
+			httpmock.ZeroCallCounters()
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+			UpdateHandler(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+			}
+			calls := httpmock.GetCallCountInfo()
+			if got := calls[streams]; got != 1 {
+				t.Errorf("expected streams to be fetched once, got %d", got)
+			}
+			if got := calls[put] == 1; got != tc.wantUpdate {
+				t.Errorf("expected activity update %v, got %v", tc.wantUpdate, got)
+			}
+		})
+	}
+}
+
+func TestRepeatEvents(t *testing.T) {
+	r := setupUpdateTest(t, "activity.json", "")
+	httpmock.RegisterResponder("GET", "https://www.strava.com/api/v3/activities/789",
+		httpmock.NewStringResponder(500, ""))
+	httpmock.RegisterResponder("GET", "https://www.strava.com/api/v3/activities/999",
+		httpmock.NewStringResponder(404, `{"message":"Record Not Found","errors":[{"resource":"Activity","field":"id","code":"invalid"}]}`))
+
+	const get = `GET =~^https://www\.strava\.com/api/v3/activities/\d+\z`
+	tests := []struct {
+		name        string
+		webhookBody string
+		wantStatus  int
+		wantGet     bool
+	}{
+		{"event A", `{"aspect_type": "create", "object_id": 123, "owner_id": 1, "event_time": 1000}`, http.StatusOK, true},
+		{"event B", `{"aspect_type": "create", "object_id": 456, "owner_id": 1, "event_time": 1001}`, http.StatusOK, true},
+		{"event A retried", `{"aspect_type": "create", "object_id": 123, "owner_id": 1, "event_time": 1000}`, http.StatusOK, false},
+		{"failed event", `{"aspect_type": "create", "object_id": 789, "owner_id": 1, "event_time": 1002}`, http.StatusInternalServerError, false},
+		{"deleted activity", `{"aspect_type": "create", "object_id": 999, "owner_id": 1, "event_time": 1003}`, http.StatusOK, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			httpmock.ZeroCallCounters()
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tc.webhookBody))
+			rr := httptest.NewRecorder()
+			UpdateHandler(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tc.wantStatus)
+			}
+			if got := httpmock.GetCallCountInfo()[get] == 1; got != tc.wantGet {
+				t.Errorf("expected activity to be processed %v, got %v", tc.wantGet, got)
+			}
+		})
+	}
+
+	if r.Exists(eventKey(&strava.WebhookPayload{ObjectID: 789, AspectType: "create", EventTime: 1002})) {
+		t.Error("expected failed event to be forgotten so it can be retried")
+	}
+	if ttl := r.TTL(eventKey(&strava.WebhookPayload{ObjectID: 123, AspectType: "create", EventTime: 1000})); ttl != eventTTL {
+		t.Errorf("expected processed event to expire after %v, got %v", eventTTL, ttl)
+	}
+}
+
+func TestInlineFailures(t *testing.T) {
+	setupUpdateTest(t, "activity.json", "")
+	httpmock.RegisterResponder("GET", "https://www.strava.com/api/v3/activities/123",
+		httpmock.NewStringResponder(500, ""))
+	// Strava limits reads separately so the overall usage can be under the limit
+	rateLimited := httpmock.NewStringResponse(429, `{"message":"Rate Limit Exceeded"}`)
+	rateLimited.Header.Set("X-RateLimit-Limit", "200,2000")
+	rateLimited.Header.Set("X-RateLimit-Usage", "10,100")
+	httpmock.RegisterResponder("GET", "https://www.strava.com/api/v3/activities/456",
+		httpmock.ResponderFromResponse(rateLimited))
+
+	tests := []struct {
+		name           string
+		webhookBody    string
+		wantStatus     int
+		wantDeadLetter string
+		wantAttempts   int
+	}{
+		{"failed delivery", `{"aspect_type": "create", "object_id": 123, "owner_id": 1, "event_time": 1000}`, http.StatusInternalServerError, "", 0},
+		{"failed redelivery", `{"aspect_type": "create", "object_id": 123, "owner_id": 1, "event_time": 1000}`, http.StatusInternalServerError, "", 0},
+		{"failed last delivery", `{"aspect_type": "create", "object_id": 123, "owner_id": 1, "event_time": 1000}`, http.StatusOK, "123-create-1000", 3},
+		{"rate limited", `{"aspect_type": "create", "object_id": 456, "owner_id": 1, "event_time": 1001}`, http.StatusOK, "456-create-1001", 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tc.webhookBody))
+			rr := httptest.NewRecorder()
+			UpdateHandler(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tc.wantStatus)
+			}
+			dls, err := ListDeadLetters(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error listing dead letters: %v", err)
+			}
+			if tc.wantDeadLetter == "" {
+				if len(dls) != 0 {
+					t.Errorf("expected no dead letters until the last delivery, got %d", len(dls))
+				}
+				return
+			}
+			dl, err := GetDeadLetter(context.Background(), tc.wantDeadLetter)
+			if err != nil {
+				t.Fatalf("expected dead letter %s, got %v", tc.wantDeadLetter, err)
+			}
+			if dl.Job.Attempts != tc.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", tc.wantAttempts, dl.Job.Attempts)
+			}
+		})
+	}
+}
+
+func TestQueuedEvents(t *testing.T) {
+	setupUpdateTest(t, "activity.json", "rules:\n  - name: Rename\n    set:\n      name: Queued\n      weather: false\n")
+
+	q := queue.NewMemoryQueue(1)
+	SetQueue(q)
+	defer SetQueue(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"aspect_type": "create", "object_id": 123, "owner_id": 1, "event_time": 1000}`))
+	rr := httptest.NewRecorder()
+	UpdateHandler(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "queued" {
+		t.Fatalf("expected event to be queued, got %d %q", rr.Code, rr.Body.String())
+	}
+	if n := httpmock.GetTotalCallCount(); n != 0 {
+		t.Errorf("expected no API calls before the job is processed, got %d", n)
+	}
+
+	job, err := q.Dequeue(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error dequeuing job: %v", err)
+	}
+	if job.Event.ObjectID != 123 {
+		t.Errorf("expected job for activity 123, got %d", job.Event.ObjectID)
+	}
+	if err := ProcessJob(context.Background(), job); err != nil {
+		t.Fatalf("unexpected error processing job: %v", err)
+	}
+	if n := httpmock.GetCallCountInfo()[`PUT =~^https://www\.strava\.com/api/v3/activities/\d+\z`]; n != 1 {
+		t.Errorf("expected activity to be updated once, got %d", n)
+	}
+}
+
+func TestDeleteEvent(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	setNow(t, 1000)
+
+	r := miniredis.RunT(t)
+	defer r.Close()
+	t.Setenv("REDIS_URL", "redis://"+r.Addr())
+	r.Set(strava.TokenKey(1), "token")
+	r.Set(appliedUpdateKey(123), `{"update":{"name":"Dog walk"}}`)
+	r.Set(appliedUpdateKey(456), `{"update":{"name":"Ride"}}`)
+	// Events and dead letters for the activities, and for others whose IDs start the same
+	events := map[int64]string{}
+	for _, id := range []int64{123, 1234, 456} {
+		events[id] = eventKey(&strava.WebhookPayload{ObjectID: id, AspectType: "create", EventTime: 900})
+		r.Set(events[id], "900")
+	}
+	r.Set("dead_letter:456-create-900", `{"id":"456-create-900"}`)
+	r.Set("dead_letter:4567-create-900", `{"id":"4567-create-900"}`)
+
+	tests := []struct {
+		name       string
+		id         int64
+		wantExists []string
+		wantGone   []string
+	}{
+		{
+			"one activity",
+			456,
+			[]string{appliedUpdateKey(123), events[123], "dead_letter:4567-create-900"},
+			[]string{appliedUpdateKey(456), events[456], "dead_letter:456-create-900"},
+		},
+		{
+			"another activity",
+			123,
+			[]string{events[1234], "dead_letter:4567-create-900"},
+			[]string{appliedUpdateKey(123), events[123]},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"aspect_type": "delete", "object_type": "activity", "object_id": %d, "owner_id": 1}`, tc.id)
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+			rr := httptest.NewRecorder()
+			UpdateHandler(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, http.StatusOK)
+			}
+			for _, k := range tc.wantExists {
+				if !r.Exists(k) {
+					t.Errorf("expected %s to exist", k)
+				}
+			}
+			for _, k := range tc.wantGone {
+				if r.Exists(k) {
+					t.Errorf("expected %s to be deleted", k)
+				}
+			}
+		})
+	}
+}
+
+func TestOwnUpdate(t *testing.T) {
+	last := &appliedUpdate{Update: strava.UpdatableActivity{Name: "Dog walk", Private: strava.Bool(true)}, Time: 1000}
+	sportType := &appliedUpdate{Update: strava.UpdatableActivity{SportType: "VirtualRide"}, Time: 1000}
+
+	tests := []struct {
+		name    string
+		webhook strava.WebhookPayload
+		last    *appliedUpdate
+		want    bool
+	}{
+		{"never updated", strava.WebhookPayload{EventTime: 1001, Updates: strava.Updates{Title: "Dog walk"}}, nil, false},
+		{"our title", strava.WebhookPayload{EventTime: 1001, Updates: strava.Updates{Title: "Dog walk"}}, last, true},
+		{"our privacy", strava.WebhookPayload{EventTime: 1001, Updates: strava.Updates{Title: "Dog walk", Private: "true"}}, last, true},
+		{"other fields", strava.WebhookPayload{EventTime: 1001}, last, true},
+		{"different title", strava.WebhookPayload{EventTime: 1001, Updates: strava.Updates{Title: "Walk"}}, last, false},
+		{"different type", strava.WebhookPayload{EventTime: 1001, Updates: strava.Updates{Type: "Hike"}}, last, false},
+		{"our sport type", strava.WebhookPayload{EventTime: 1001, Updates: strava.Updates{Type: "VirtualRide"}}, sportType, true},
+		{"not made private by us", strava.WebhookPayload{EventTime: 1001, Updates: strava.Updates{Private: "true"}}, sportType, false},
+		{"made public", strava.WebhookPayload{EventTime: 1001, Updates: strava.Updates{Private: "false"}}, last, false},
+		{"long after our update", strava.WebhookPayload{EventTime: 5000, Updates: strava.Updates{Title: "Dog walk"}}, last, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ownUpdate(&tc.webhook, tc.last); got != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
 type MockClient struct {
 	DoFunc func(req *http.Request) (*http.Response, error)
 }
@@ -146,6 +514,11 @@ func TestConstructUpdate(t *testing.T) {
 		fmt.Fprintln(w, string(resp))
 	})
 
+	rs, err := rules.Load("../../../rules.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error loading rules: %v", err)
+	}
+
 	tests := []struct {
 		name    string
 		want    *strava.UpdatableActivity
@@ -165,16 +538,15 @@ func TestConstructUpdate(t *testing.T) {
 			"set dog walking title for early morning long walks",
 			&strava.UpdatableActivity{
 				Name:    "Emptying & Exercising the 🐶",
-				Private: false,
 				GearID:  "g10043849",
-				WithPet: true,
+				WithPet: strava.Bool(true),
 			},
 			"walk_early_morning_long.json",
 		},
 		{
 			"set gear and mute afternoon walks",
 			&strava.UpdatableActivity{
-				HideFromHome: true,
+				HideFromHome: strava.Bool(true),
 				GearID:       "g10043849",
 			},
 			"walk_afternoon.json",
@@ -182,7 +554,7 @@ func TestConstructUpdate(t *testing.T) {
 		{
 			"set gear and mute short early morning walks",
 			&strava.UpdatableActivity{
-				HideFromHome: true,
+				HideFromHome: strava.Bool(true),
 				GearID:       "g10043849",
 			},
 			"walk_early_short.json",
@@ -191,7 +563,7 @@ func TestConstructUpdate(t *testing.T) {
 			"set humane burpees title and mute",
 			&strava.UpdatableActivity{
 				Name:         "Humane Burpees",
-				HideFromHome: true,
+				HideFromHome: strava.Bool(true),
 			},
 			"humane_burpees.json",
 		},
@@ -200,7 +572,7 @@ func TestConstructUpdate(t *testing.T) {
 			&strava.UpdatableActivity{
 				Name:    "TR: Capulin",
 				GearID:  "b9880609",
-				Trainer: true,
+				Trainer: strava.Bool(true),
 			},
 			"trainerroad.json",
 		},
@@ -215,7 +587,7 @@ func TestConstructUpdate(t *testing.T) {
 			"set gear to trainer for Zwift activities",
 			&strava.UpdatableActivity{
 				GearID:  "b9880609",
-				Trainer: true,
+				Trainer: strava.Bool(true),
 			},
 			"zwift.json",
 		},
@@ -300,7 +672,7 @@ func TestConstructUpdate(t *testing.T) {
 			"set rowing title: warmup",
 			&strava.UpdatableActivity{
 				Name:         "Warm-up Row",
-				HideFromHome: true,
+				HideFromHome: strava.Bool(true),
 			},
 			"row_warmup.json",
 		},
@@ -308,7 +680,7 @@ func TestConstructUpdate(t *testing.T) {
 			"add weather to pop'd description",
 			&strava.UpdatableActivity{
 				Name:         "Warm-up Row",
-				HideFromHome: true,
+				HideFromHome: strava.Bool(true),
 				Description:  "Test activity description\n\nThe Pain Cave: ☀️ Clear Sky | 🌡 19-19°C | 👌 16°C | 💦 64-64% | AQI 💚\n",
 			},
 			"row_add_weather.json",
@@ -333,7 +705,7 @@ func TestConstructUpdate(t *testing.T) {
 			"adds weather for pain cave for virtual rides",
 			&strava.UpdatableActivity{
 				GearID:      "b9880609",
-				Trainer:     true,
+				Trainer:     strava.Bool(true),
 				Description: "Test virtualride description\n\nThe Pain Cave: ☀️ Clear Sky | 🌡 19-19°C | 👌 16°C | 💦 64-64% | AQI 💚\n",
 			},
 			"virtualride.json",
@@ -364,7 +736,7 @@ func TestConstructUpdate(t *testing.T) {
 				t.Errorf("unexpected error parsing test input: %v", err)
 			}
 
-			got, _ := constructUpdate(context.Background(), rc, &a, trcal)
+			got, _, _ := constructUpdate(context.Background(), rc, &a, rules.Event{AspectType: rules.EventCreate}, trcal, rs)
 			if !reflect.DeepEqual(got, tc.want) {
 				t.Errorf("expected %+v, got %+v", tc.want, got)
 			}
@@ -372,6 +744,46 @@ func TestConstructUpdate(t *testing.T) {
 	}
 }
 
+// setupUpdateTest mocks the Strava API to return the activity in the testdata fixture,
+// stores a token for athlete 1 in a fresh Redis and, if set, replaces the rules for the
+// duration of the test.
+func setupUpdateTest(t *testing.T, fixture, rulesYAML string) *miniredis.Miniredis {
+	t.Helper()
+
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	setNow(t, 1000)
+
+	httpmock.Activate()
+	t.Cleanup(httpmock.DeactivateAndReset)
+
+	ot, _ := os.ReadFile("testdata/oauth_token.json")
+	activity, _ := os.ReadFile("testdata/" + fixture)
+
+	httpmock.RegisterResponder("POST", "https://www.strava.com/oauth/token",
+		httpmock.NewStringResponder(200, string(ot)))
+	httpmock.RegisterResponder("GET", `=~^https://www\.strava\.com/api/v3/activities/\d+\z`,
+		httpmock.NewStringResponder(200, string(activity)))
+	httpmock.RegisterResponder("PUT", `=~^https://www\.strava\.com/api/v3/activities/\d+\z`,
+		httpmock.NewStringResponder(200, string(activity)))
+
+	r := miniredis.RunT(t)
+	r.Set(strava.TokenKey(1), string(ot))
+	t.Setenv("REDIS_URL", "redis://"+r.Addr())
+
+	if rulesYAML != "" {
+		rs, err := rules.Parse([]byte(rulesYAML))
+		if err != nil {
+			t.Fatalf("unexpected error parsing rules: %v", err)
+		}
+		prev := ruleSet
+		ruleSet = rs
+		t.Cleanup(func() { ruleSet = prev })
+	}
+
+	return r
+}
+
 // Setup establishes a test Server that can be used to provide mock responses during testing.
 // It returns a pointer to a client, a mux, the server URL and a teardown function that
 // must be called when testing is complete.
@@ -0,0 +1,153 @@
+package update
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+// Reasons webhook events are rejected, used as the keys of the rejections counter.
+const (
+	rejectSourceIP       = "source_ip"
+	rejectEventTime      = "event_time"
+	rejectSubscription   = "subscription"
+	rejectUnknownAthlete = "unknown_athlete"
+)
+
+// maxEventAge is how old an event can be. Repeat events are only detected for eventTTL
+// so older events are rejected rather than risk processing a replayed event again.
+const maxEventAge = eventTTL
+
+// maxEventSkew is how far in the future an event can be to allow for clock differences.
+const maxEventSkew = 5 * time.Minute
+
+// rejections counts the webhook events rejected for each reason since the app started.
+var rejections = expvar.NewMap("webhook_rejections")
+
+// now returns the current time, and is replaced in tests.
+var now = time.Now
+
+// checkRequest returns why the webhook request should be rejected before looking at the
+// cache, or "" if it shouldn't be. Only events sent from an address in WEBHOOK_ALLOWED_IPS,
+// if it's set, and that happened recently are accepted.
+func checkRequest(r *http.Request, webhook *strava.WebhookPayload) (string, error) {
+	if allowed := os.Getenv("WEBHOOK_ALLOWED_IPS"); allowed != "" {
+		ok, err := allowedSource(r, allowed)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return rejectSourceIP, nil
+		}
+	}
+
+	age := now().Sub(time.Unix(webhook.EventTime, 0))
+	if age > maxEventAge || age < -maxEventSkew {
+		return rejectEventTime, nil
+	}
+	return "", nil
+}
+
+// checkEvent returns why the webhook event should be rejected, or "" if it shouldn't be.
+// Events must be for our subscription, once we know it, and for an athlete who has authorized us.
+func checkEvent(ctx context.Context, c cache.Cache, webhook *strava.WebhookPayload) (string, error) {
+	sub, err := strava.StoredSubscription(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	// The subscription is stored when an athlete authorizes or the subscription command ensures it's set up
+	if sub != nil && webhook.SubscriptionID != sub.ID {
+		return rejectSubscription, nil
+	}
+
+	known, err := knownAthlete(ctx, c, webhook.OwnerID)
+	if err != nil {
+		return "", fmt.Errorf("getting athlete token: %w", err)
+	}
+	if !known {
+		return rejectUnknownAthlete, nil
+	}
+	return "", nil
+}
+
+// rejectEvent counts and logs the rejected event.
+func rejectEvent(r *http.Request, webhook *strava.WebhookPayload, reason string) {
+	rejections.Add(reason, 1)
+	count := rejections.Get(reason).String()
+	slog.Warn("rejecting webhook event", "reason", reason, "count", count, "remote_addr", sanitizeForLog(r.RemoteAddr), //nolint:gosec // G706 noise
+		"id", webhook.ObjectID, "owner_id", webhook.OwnerID, "subscription_id", webhook.SubscriptionID, "event_time", webhook.EventTime)
+}
+
+// allowedSource reports whether the request came from an address in the comma separated
+// list of IP addresses and CIDR ranges. The address is taken from the last entry in the
+// WEBHOOK_CLIENT_IP_HEADER header, if set, for when the app is behind a proxy.
+func allowedSource(r *http.Request, allowed string) (bool, error) {
+	ip, ok := sourceIP(r)
+	if !ok {
+		return false, nil
+	}
+
+	for entry := range strings.SplitSeq(allowed, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return false, fmt.Errorf("parsing WEBHOOK_ALLOWED_IPS: %w", err)
+			}
+			if addr.Unmap() == ip {
+				return true, nil
+			}
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return false, fmt.Errorf("parsing WEBHOOK_ALLOWED_IPS: %w", err)
+		}
+		if prefix.Contains(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sourceIP returns the address the request came from.
+func sourceIP(r *http.Request) (netip.Addr, bool) {
+	addr := r.RemoteAddr
+	if header := os.Getenv("WEBHOOK_CLIENT_IP_HEADER"); header != "" {
+		// The proxy in front of us appends the address it saw so earlier entries can't be trusted
+		values := strings.Split(r.Header.Get(header), ",")
+		addr = strings.TrimSpace(values[len(values)-1])
+	}
+
+	// RemoteAddr, and the addresses some proxies add, include the port
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// VarsHandler serves the app's counters, including webhook rejections, as JSON. It
+// requires the ADMIN_TOKEN bearer token and responds not found without it.
+func VarsHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		http.NotFound(w, r)
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,165 @@
+package update
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jarcoal/httpmock"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+// setNow fixes the time used to check events to the Unix timestamp for the duration of the test.
+func setNow(t *testing.T, unix int64) {
+	t.Helper()
+	prev := now
+	now = func() time.Time { return time.Unix(unix, 0) }
+	t.Cleanup(func() { now = prev })
+}
+
+func TestCheckRequest(t *testing.T) {
+	setNow(t, 100_000)
+
+	tests := []struct {
+		name       string
+		allowed    string
+		header     string
+		remoteAddr string
+		forwarded  string
+		eventTime  int64
+		want       string
+		wantErr    bool
+	}{
+		{"no allow list", "", "", "192.0.2.1:1234", "", 100_000, "", false},
+		{"allowed address", "198.51.100.7, 192.0.2.1", "", "192.0.2.1:1234", "", 100_000, "", false},
+		{"allowed range", "192.0.2.0/24", "", "192.0.2.1:1234", "", 100_000, "", false},
+		{"allowed IPv6", "2001:db8::/32", "", "[2001:db8::1]:1234", "", 100_000, "", false},
+		{"disallowed address", "198.51.100.7", "", "192.0.2.1:1234", "", 100_000, rejectSourceIP, false},
+		{"allowed address from proxy", "192.0.2.1", "X-Forwarded-For", "127.0.0.1:1234", "203.0.113.9, 192.0.2.1", 100_000, "", false},
+		{"allowed address and port from proxy", "192.0.2.1", "X-Forwarded-For", "127.0.0.1:1234", "203.0.113.9, 192.0.2.1:5678", 100_000, "", false},
+		{"allowed IPv6 address and port from proxy", "2001:db8::/32", "X-Forwarded-For", "127.0.0.1:1234", "[2001:db8::1]:5678", 100_000, "", false},
+		{"spoofed address from proxy", "192.0.2.1", "X-Forwarded-For", "127.0.0.1:1234", "192.0.2.1, 203.0.113.9", 100_000, rejectSourceIP, false},
+		{"missing proxy header", "192.0.2.1", "X-Forwarded-For", "192.0.2.1:1234", "", 100_000, rejectSourceIP, false},
+		{"invalid allow list", "not-an-ip", "", "192.0.2.1:1234", "", 100_000, "", true},
+		{"recent event", "", "", "192.0.2.1:1234", "", 100_000 - 3600, "", false},
+		{"old event", "", "", "192.0.2.1:1234", "", 100_000 - int64(maxEventAge.Seconds()) - 1, rejectEventTime, false},
+		{"slightly future event", "", "", "192.0.2.1:1234", "", 100_000 + 60, "", false},
+		{"future event", "", "", "192.0.2.1:1234", "", 100_000 + 3600, rejectEventTime, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("WEBHOOK_ALLOWED_IPS", tc.allowed)
+			t.Setenv("WEBHOOK_CLIENT_IP_HEADER", tc.header)
+			req := httptest.NewRequest(http.MethodPost, "/webhook", http.NoBody)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+
+			got, err := checkRequest(req, &strava.WebhookPayload{EventTime: tc.eventTime})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRejectedEvents(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	setNow(t, 1000)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ot, _ := os.ReadFile("testdata/oauth_token.json")
+	r := miniredis.RunT(t)
+	defer r.Close()
+	r.Set(strava.TokenKey(1), string(ot))
+	r.Set("strava_subscription", `{"id":120475}`)
+	t.Setenv("REDIS_URL", "redis://"+r.Addr())
+	t.Setenv("WEBHOOK_ALLOWED_IPS", "192.0.2.0/24")
+
+	tests := []struct {
+		name        string
+		remoteAddr  string
+		webhookBody string
+		wantStatus  int
+		wantReason  string
+	}{
+		{"disallowed source", "203.0.113.9:1234", `{"aspect_type": "create", "object_id": 123, "owner_id": 1, "subscription_id": 120475, "event_time": 1000}`, http.StatusForbidden, rejectSourceIP},
+		{"old event", "192.0.2.1:1234", `{"aspect_type": "create", "object_id": 123, "owner_id": 1, "subscription_id": 120475, "event_time": -100000}`, http.StatusOK, rejectEventTime},
+		{"other subscription", "192.0.2.1:1234", `{"aspect_type": "create", "object_id": 123, "owner_id": 1, "subscription_id": 1, "event_time": 1000}`, http.StatusOK, rejectSubscription},
+		{"unknown athlete", "192.0.2.1:1234", `{"aspect_type": "create", "object_id": 123, "owner_id": 2, "subscription_id": 120475, "event_time": 1000}`, http.StatusOK, rejectUnknownAthlete},
+		{"deletion for unknown athlete", "192.0.2.1:1234", `{"aspect_type": "delete", "object_id": 123, "owner_id": 2, "subscription_id": 120475, "event_time": 1000}`, http.StatusOK, rejectUnknownAthlete},
+		{"deauthorization for other subscription", "192.0.2.1:1234", `{"aspect_type": "update", "object_type": "athlete", "object_id": 1, "owner_id": 1, "subscription_id": 1, "event_time": 1000, "updates": {"authorized": "false"}}`, http.StatusOK, rejectSubscription},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := rejectionCount(tc.wantReason)
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tc.webhookBody))
+			req.RemoteAddr = tc.remoteAddr
+			rr := httptest.NewRecorder()
+			UpdateHandler(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tc.wantStatus)
+			}
+			if got := rejectionCount(tc.wantReason); got != before+1 {
+				t.Errorf("expected %s rejections to be counted, got %d after %d", tc.wantReason, got, before)
+			}
+		})
+	}
+
+	if n := httpmock.GetTotalCallCount(); n != 0 {
+		t.Errorf("expected no Strava API calls for rejected events, got %d", n)
+	}
+	if !r.Exists(strava.TokenKey(1)) {
+		t.Error("expected token to be kept")
+	}
+}
+
+func rejectionCount(reason string) int64 {
+	if v, ok := rejections.Get(reason).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestVarsHandler(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+
+	for _, tc := range []struct {
+		name       string
+		auth       string
+		wantStatus int
+	}{
+		{"authorized", "Bearer secret", http.StatusOK},
+		{"unauthorized", "", http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/debug/vars", http.NoBody)
+			req.Header.Set("Authorization", tc.auth)
+			rr := httptest.NewRecorder()
+			VarsHandler(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus == http.StatusOK && !strings.Contains(rr.Body.String(), `"webhook_rejections"`) {
+				t.Errorf("expected webhook rejections in %s", rr.Body.String())
+			}
+		})
+	}
+}
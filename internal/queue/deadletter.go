@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lildude/strautomagically/internal/cache"
+)
+
+// ErrNotFound is returned when a dead letter doesn't exist.
+var ErrNotFound = errors.New("dead letter not found")
+
+// deadLetterPrefix is the prefix of the cache keys of dead letters.
+const deadLetterPrefix = "dead_letter:"
+
+// DeadLetter is a job that failed on its last attempt.
+type DeadLetter struct {
+	ID  string `json:"id"`
+	Job Job    `json:"job"`
+	// Error is the error from the last attempt.
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// DeadLetters stores jobs that failed on every attempt so they can be replayed once
+// whatever caused them to fail has been fixed.
+type DeadLetters struct {
+	cache cache.Cache
+}
+
+// NewDeadLetters returns a dead letter store using the cache.
+func NewDeadLetters(c cache.Cache) *DeadLetters {
+	return &DeadLetters{cache: c}
+}
+
+// deadLetterKey returns the cache key for the dead letter.
+func deadLetterKey(id string) string {
+	return deadLetterPrefix + id
+}
+
+// ActivityPrefix returns the prefix of the cache keys of the activity's dead letters.
+func ActivityPrefix(id int64) string {
+	return fmt.Sprintf("%s%d-", deadLetterPrefix, id)
+}
+
+// Add stores the failed job. A job that fails again replaces its previous dead letter.
+func (d *DeadLetters) Add(ctx context.Context, job *Job, jobErr error) (*DeadLetter, error) {
+	e := job.Event
+	dl := &DeadLetter{
+		ID:       fmt.Sprintf("%d-%s-%d", e.ObjectID, e.AspectType, e.EventTime),
+		Job:      *job,
+		Error:    jobErr.Error(),
+		FailedAt: time.Now().UTC(),
+	}
+	if err := d.cache.SetJSON(ctx, deadLetterKey(dl.ID), dl); err != nil {
+		return nil, fmt.Errorf("storing dead letter: %w", err)
+	}
+	return dl, nil
+}
+
+// List returns the dead letters, oldest first.
+func (d *DeadLetters) List(ctx context.Context) ([]*DeadLetter, error) {
+	keys, err := d.cache.Keys(ctx, deadLetterPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing dead letters: %w", err)
+	}
+
+	dls := make([]*DeadLetter, 0, len(keys))
+	for _, key := range keys {
+		dl, err := d.Get(ctx, strings.TrimPrefix(key, deadLetterPrefix))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		dls = append(dls, dl)
+	}
+	slices.SortStableFunc(dls, func(a, b *DeadLetter) int { return a.FailedAt.Compare(b.FailedAt) })
+	return dls, nil
+}
+
+// Get returns the dead letter with the ID.
+func (d *DeadLetters) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	exists, err := d.cache.Exists(ctx, deadLetterKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("getting dead letter: %w", err)
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	var dl DeadLetter
+	if err := d.cache.GetJSON(ctx, deadLetterKey(id), &dl); err != nil {
+		return nil, fmt.Errorf("getting dead letter: %w", err)
+	}
+	return &dl, nil
+}
+
+// Delete removes the dead letter with the ID.
+func (d *DeadLetters) Delete(ctx context.Context, id string) error {
+	return d.cache.Delete(ctx, deadLetterKey(id))
+}
+
+// Replay runs the handler for the dead letter's job and deletes the dead letter if it
+// succeeds. The job's attempts are reset so it's retried in full if it's queued again.
+func (d *DeadLetters) Replay(ctx context.Context, id string, handler Handler) error {
+	dl, err := d.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	job := dl.Job
+	job.Attempts = 0
+	if err := handler(ctx, &job); err != nil {
+		return err
+	}
+	return d.Delete(ctx, id)
+}
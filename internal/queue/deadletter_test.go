@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+func TestDeadLetters(t *testing.T) {
+	r := miniredis.RunT(t)
+	defer r.Close()
+	ctx := context.Background()
+
+	c, err := cache.NewRedisCache(ctx, "redis://"+r.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDeadLetters(c)
+
+	if dls, err := d.List(ctx); err != nil || len(dls) != 0 {
+		t.Fatalf("expected no dead letters, got %v, %v", dls, err)
+	}
+
+	job := &Job{Event: strava.WebhookPayload{ObjectID: 123, AspectType: "create", EventTime: 1000}, Attempts: 5}
+	dl, err := d.Add(ctx, job, errors.New("token expired"))
+	if err != nil {
+		t.Fatalf("unexpected error adding dead letter: %v", err)
+	}
+	if dl.ID != "123-create-1000" {
+		t.Errorf("expected ID 123-create-1000, got %s", dl.ID)
+	}
+	// Failing again replaces the dead letter
+	if _, err := d.Add(ctx, job, errors.New("still expired")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Add(ctx, &Job{Event: strava.WebhookPayload{ObjectID: 456, AspectType: "update", EventTime: 1001}}, errors.New("outage")); err != nil {
+		t.Fatal(err)
+	}
+
+	dls, err := d.List(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing dead letters: %v", err)
+	}
+	if len(dls) != 2 || dls[0].ID != "123-create-1000" || dls[1].ID != "456-update-1001" {
+		t.Fatalf("expected 2 dead letters in order, got %+v", dls)
+	}
+
+	got, err := d.Get(ctx, "123-create-1000")
+	if err != nil {
+		t.Fatalf("unexpected error getting dead letter: %v", err)
+	}
+	if got.Error != "still expired" || got.Job.Attempts != 5 || got.FailedAt.IsZero() {
+		t.Errorf("unexpected dead letter %+v", got)
+	}
+	if _, err := d.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	// Failed replays keep the dead letter
+	replayErr := errors.New("still failing")
+	if err := d.Replay(ctx, "123-create-1000", func(context.Context, *Job) error { return replayErr }); !errors.Is(err, replayErr) {
+		t.Errorf("expected replay error, got %v", err)
+	}
+	var replayed *Job
+	if err := d.Replay(ctx, "123-create-1000", func(_ context.Context, j *Job) error { replayed = j; return nil }); err != nil {
+		t.Fatalf("unexpected error replaying: %v", err)
+	}
+	if replayed == nil || replayed.Event.ObjectID != 123 || replayed.Attempts != 0 {
+		t.Errorf("expected job 123 with attempts reset to be replayed, got %+v", replayed)
+	}
+	if _, err := d.Get(ctx, "123-create-1000"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected replayed dead letter to be deleted, got %v", err)
+	}
+	if dls, _ := d.List(ctx); len(dls) != 1 {
+		t.Errorf("expected 1 dead letter left, got %d", len(dls))
+	}
+}
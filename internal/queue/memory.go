@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryQueue is an in-process queue. Waiting jobs are lost if the process exits
+// so it's best suited to development.
+type MemoryQueue struct {
+	jobs chan *Job
+	done chan struct{}
+	once sync.Once
+}
+
+// NewMemoryQueue returns an in-process queue holding up to size waiting jobs.
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{jobs: make(chan *Job, size), done: make(chan struct{})}
+}
+
+// Enqueue adds the job to the queue, blocking if the queue is full.
+func (q *MemoryQueue) Enqueue(ctx context.Context, job *Job) error {
+	select {
+	case <-q.done:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	case <-q.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dequeue returns the next job, blocking until one is available.
+func (q *MemoryQueue) Dequeue(ctx context.Context) (*Job, error) {
+	select {
+	case job := <-q.jobs:
+		return job, nil
+	case <-q.done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Ack does nothing as jobs are removed from the queue when they're dequeued.
+func (q *MemoryQueue) Ack(_ context.Context, _ *Job) error {
+	return nil
+}
+
+// Close stops the queue. Waiting jobs are discarded.
+func (q *MemoryQueue) Close() error {
+	q.once.Do(func() { close(q.done) })
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package queue implements the job queue used to process webhook events in the background.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lildude/strautomagically/internal/client"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+// rateLimitSlack is added to the rate limit reset time before trying a job again in case
+// our clock is ahead of the API's.
+var rateLimitSlack = 5 * time.Second
+
+// ErrClosed is returned by Dequeue when the queue has been closed.
+var ErrClosed = errors.New("queue closed")
+
+// Job is a webhook event waiting to be processed.
+type Job struct {
+	Event strava.WebhookPayload `json:"event"`
+	// Attempts is the number of times processing the job has failed.
+	Attempts   int       `json:"attempts"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+
+	// raw is the job as it was stored in the queue, used to acknowledge it.
+	raw string
+}
+
+// Queue holds jobs until a worker is ready to process them.
+type Queue interface {
+	// Enqueue adds the job to the queue.
+	Enqueue(ctx context.Context, job *Job) error
+	// Dequeue blocks until a job is available, ctx is done or the queue is closed.
+	Dequeue(ctx context.Context) (*Job, error)
+	// Ack removes a dequeued job from the queue once it has been dealt with.
+	// Jobs that haven't been acknowledged may be processed again after a crash.
+	Ack(ctx context.Context, job *Job) error
+	Close() error
+}
+
+// Open returns the queue for the URL. "memory" is an in-process queue, which loses any
+// waiting jobs if the process exits, and "redis://" URLs are a Redis list which doesn't.
+// Only one process may work on a Redis queue, see NewRedisQueue.
+func Open(ctx context.Context, url string) (Queue, error) {
+	switch {
+	case url == "memory":
+		return NewMemoryQueue(100), nil
+	case strings.HasPrefix(url, "redis://"), strings.HasPrefix(url, "rediss://"):
+		return NewRedisQueue(ctx, url, "webhook")
+	default:
+		return nil, fmt.Errorf("unsupported queue URL %q: must be memory or a redis:// URL", url)
+	}
+}
+
+// Handler processes a job. Jobs that return an error are retried.
+type Handler func(ctx context.Context, job *Job) error
+
+// Pool is a pool of workers processing jobs from a queue. Failed jobs are retried
+// with exponential backoff until they've been attempted MaxAttempts times. Jobs that
+// fail because an API's rate limit has been used up wait until it resets and don't
+// count as an attempt.
+type Pool struct {
+	Queue   Queue
+	Handler Handler
+	// Workers is the number of jobs processed at the same time. Defaults to 1.
+	Workers int
+	// MaxAttempts is the number of times a job is attempted before giving up. Defaults to 5.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles for every retry after that. Defaults to 1s.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries. Defaults to 1m.
+	MaxBackoff time.Duration
+	// Failed is called with jobs that failed on their last attempt.
+	Failed func(ctx context.Context, job *Job, err error)
+}
+
+// Run processes jobs until ctx is done or the queue is closed.
+func (p *Pool) Run(ctx context.Context) {
+	workers := max(p.Workers, 1)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() { p.work(ctx) })
+	}
+	wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for {
+		job, err := p.Queue.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, ErrClosed) {
+				return
+			}
+			slog.Error("unable to get job from queue", "error", err)
+			if !client.Sleep(ctx, p.backoff(1)) {
+				return
+			}
+			continue
+		}
+
+		// Jobs we stopped processing part way through are left to be recovered
+		if !p.process(ctx, job) {
+			return
+		}
+		if err := p.Queue.Ack(context.WithoutCancel(ctx), job); err != nil {
+			slog.Error("unable to acknowledge job", "error", err, "id", job.Event.ObjectID)
+		}
+	}
+}
+
+// process runs the handler for the job, retrying it if it fails. It reports whether the
+// job was finished with, either succeeding or failing on its last attempt, rather than
+// ctx being done while waiting to retry it.
+func (p *Pool) process(ctx context.Context, job *Job) bool {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+
+	for {
+		err := p.Handler(ctx, job)
+		if err == nil {
+			return true
+		}
+
+		var rle *client.RateLimitError
+		if errors.As(err, &rle) {
+			slog.Warn("rate limited, deferring job", "id", job.Event.ObjectID, "until", rle.Reset)
+			if !client.Sleep(ctx, time.Until(rle.Reset)+rateLimitSlack) {
+				return false
+			}
+			continue
+		}
+		job.Attempts++
+
+		if job.Attempts >= maxAttempts {
+			slog.Error("job failed, giving up", "error", err, "id", job.Event.ObjectID, "attempts", job.Attempts)
+			if p.Failed != nil {
+				p.Failed(context.WithoutCancel(ctx), job, err)
+			}
+			return true
+		}
+
+		delay := p.backoff(job.Attempts)
+		slog.Warn("job failed, retrying", "error", err, "id", job.Event.ObjectID, "attempts", job.Attempts, "retry_in", delay)
+		if !client.Sleep(ctx, delay) {
+			return false
+		}
+	}
+}
+
+// backoff returns the delay before the retry after the given number of failed attempts.
+func (p *Pool) backoff(attempts int) time.Duration {
+	base, maxDelay := p.Backoff, p.MaxBackoff
+	if base <= 0 {
+		base = time.Second
+	}
+	if maxDelay <= 0 {
+		maxDelay = time.Minute
+	}
+	return client.Backoff(base, maxDelay, attempts)
+}
@@ -0,0 +1,281 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/lildude/strautomagically/internal/client"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+func TestOpen(t *testing.T) {
+	r := miniredis.RunT(t)
+	defer r.Close()
+
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"memory", false},
+		{"redis://" + r.Addr(), false},
+		{"sqs://queue", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			q, err := Open(context.Background(), tc.url)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if q != nil {
+				q.Close()
+			}
+		})
+	}
+}
+
+// testQueue checks jobs pass through the queue in order.
+func testQueue(t *testing.T, q Queue) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, id := range []int64{1, 2} {
+		if err := q.Enqueue(ctx, &Job{Event: strava.WebhookPayload{ObjectID: id}}); err != nil {
+			t.Fatalf("unexpected error enqueuing job: %v", err)
+		}
+	}
+	for _, want := range []int64{1, 2} {
+		job, err := q.Dequeue(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error dequeuing job: %v", err)
+		}
+		if job.Event.ObjectID != want {
+			t.Errorf("expected job %d, got %d", want, job.Event.ObjectID)
+		}
+		if err := q.Ack(ctx, job); err != nil {
+			t.Errorf("unexpected error acknowledging job: %v", err)
+		}
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Dequeue(cctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded dequeuing from empty queue, got %v", err)
+	}
+
+	q.Close()
+	if _, err := q.Dequeue(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected closed error, got %v", err)
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	testQueue(t, NewMemoryQueue(10))
+}
+
+func TestRedisQueue(t *testing.T) {
+	r := miniredis.RunT(t)
+	defer r.Close()
+
+	q, err := NewRedisQueue(context.Background(), "redis://"+r.Addr(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testQueue(t, q)
+}
+
+func TestRedisQueueRecovers(t *testing.T) {
+	r := miniredis.RunT(t)
+	defer r.Close()
+	ctx := context.Background()
+
+	q, err := NewRedisQueue(ctx, "redis://"+r.Addr(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(ctx, &Job{Event: strava.WebhookPayload{ObjectID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	// Dequeue without acknowledging the job, as if we crashed while processing it
+	if _, err := q.Dequeue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	q, err = NewRedisQueue(ctx, "redis://"+r.Addr(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	job, err := q.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("expected job to be recovered, got %v", err)
+	}
+	if job.Event.ObjectID != 1 {
+		t.Errorf("expected job 1, got %d", job.Event.ObjectID)
+	}
+}
+
+func TestPool(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	tests := []struct {
+		name         string
+		failures     int32
+		wantCalls    int32
+		wantGiveUp   bool
+		wantAttempts int
+	}{
+		{"succeeds", 0, 1, false, 0},
+		{"succeeds after retries", 2, 3, false, 2},
+		{"gives up", 10, 3, true, 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewMemoryQueue(1)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var calls atomic.Int32
+			done := make(chan *Job, 1)
+			p := &Pool{
+				Queue:       q,
+				MaxAttempts: 3,
+				Backoff:     time.Millisecond,
+				Handler: func(_ context.Context, job *Job) error {
+					if calls.Add(1) <= tc.failures {
+						return errors.New("failed")
+					}
+					done <- job
+					return nil
+				},
+				Failed: func(_ context.Context, job *Job, _ error) {
+					done <- job
+				},
+			}
+			go p.Run(ctx)
+
+			if err := q.Enqueue(ctx, &Job{}); err != nil {
+				t.Fatal(err)
+			}
+			job := <-done
+			cancel()
+
+			if got := calls.Load(); got != tc.wantCalls {
+				t.Errorf("expected %d calls, got %d", tc.wantCalls, got)
+			}
+			if job.Attempts != tc.wantAttempts {
+				t.Errorf("expected %d failed attempts, got %d", tc.wantAttempts, job.Attempts)
+			}
+		})
+	}
+}
+
+func TestPoolLeavesUnfinishedJobs(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	r := miniredis.RunT(t)
+	defer r.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q, err := NewRedisQueue(ctx, "redis://"+r.Addr(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	failed := make(chan struct{}, 1)
+	p := &Pool{
+		Queue:   q,
+		Backoff: time.Hour,
+		Handler: func(_ context.Context, _ *Job) error {
+			failed <- struct{}{}
+			return errors.New("failed")
+		},
+	}
+	stopped := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(stopped)
+	}()
+
+	if err := q.Enqueue(ctx, &Job{Event: strava.WebhookPayload{ObjectID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	// Stop while the job is waiting to be retried
+	<-failed
+	cancel()
+	<-stopped
+
+	if got, _ := r.List("queue:test:processing"); len(got) != 1 {
+		t.Errorf("expected job to be left to be recovered, got %v", got)
+	}
+}
+
+func TestPoolDefersRateLimitedJobs(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	defer func(prev time.Duration) { rateLimitSlack = prev }(rateLimitSlack)
+	rateLimitSlack = 0
+
+	q := NewMemoryQueue(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls atomic.Int32
+	var reset time.Time
+	done := make(chan *Job, 1)
+	p := &Pool{
+		Queue:       q,
+		MaxAttempts: 1,
+		Backoff:     time.Millisecond,
+		Handler: func(_ context.Context, job *Job) error {
+			if calls.Add(1) <= 2 {
+				reset = time.Now().Add(20 * time.Millisecond)
+				return fmt.Errorf("getting activity: %w", &client.RateLimitError{Reset: reset})
+			}
+			if time.Now().Before(reset) {
+				t.Error("expected job to wait until the rate limit reset")
+			}
+			done <- job
+			return nil
+		},
+		Failed: func(_ context.Context, job *Job, _ error) {
+			done <- job
+		},
+	}
+	go p.Run(ctx)
+
+	if err := q.Enqueue(ctx, &Job{}); err != nil {
+		t.Fatal(err)
+	}
+	job := <-done
+	cancel()
+
+	if got := calls.Load(); got != 3 {
+		t.Errorf("expected 3 calls, got %d", got)
+	}
+	if job.Attempts != 0 {
+		t.Errorf("expected rate limited attempts not to count, got %d", job.Attempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	// The backoff defaults to 1s, capped at 1m
+	p := &Pool{}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 7: time.Minute, 10: time.Minute} {
+		if got := p.backoff(attempts); got != want {
+			t.Errorf("attempt %d: expected %v, got %v", attempts, want, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// pollInterval is how long Dequeue waits for a job before checking if it should stop.
+const pollInterval = 5 * time.Second
+
+// RedisQueue is a queue held in a Redis list. Dequeued jobs are moved to a processing
+// list until they're acknowledged so they aren't lost if the process crashes.
+type RedisQueue struct {
+	conn       *redis.Client
+	pending    string
+	processing string
+}
+
+// NewRedisQueue returns a queue held in the Redis list with the name. Jobs left in
+// the processing list by a previous process that crashed or stopped are moved back to
+// the queue. Only one process may work on the queue at a time as this would also move
+// back, and so repeat, jobs other processes are still working on.
+func NewRedisQueue(ctx context.Context, addr, name string) (*RedisQueue, error) {
+	opt, err := redis.ParseURL(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing redis URL: %w", err)
+	}
+	client := redis.NewClient(opt)
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("pinging redis: %w", err)
+	}
+
+	q := &RedisQueue{conn: client, pending: "queue:" + name, processing: "queue:" + name + ":processing"}
+	if err := q.recover(ctx); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// recover moves jobs from the processing list back to the queue.
+func (q *RedisQueue) recover(ctx context.Context) error {
+	for {
+		err := q.conn.RPopLPush(ctx, q.processing, q.pending).Err()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("recovering jobs: %w", err)
+		}
+	}
+}
+
+// Enqueue adds the job to the queue.
+func (q *RedisQueue) Enqueue(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("marshaling job: %w", err)
+	}
+	return q.conn.LPush(ctx, q.pending, data).Err()
+}
+
+// Dequeue moves the next job to the processing list and returns it, blocking until one is available.
+func (q *RedisQueue) Dequeue(ctx context.Context) (*Job, error) {
+	for {
+		raw, err := q.conn.BRPopLPush(ctx, q.pending, q.processing, pollInterval).Result()
+		if errors.Is(err, redis.Nil) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if errors.Is(err, redis.ErrClosed) {
+			return nil, ErrClosed
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+
+		job := &Job{raw: raw}
+		if err := json.Unmarshal([]byte(raw), job); err != nil {
+			// Drop jobs we can't read rather than failing on them forever
+			q.conn.LRem(ctx, q.processing, 1, raw)
+			return nil, fmt.Errorf("unmarshaling job: %w", err)
+		}
+		return job, nil
+	}
+}
+
+// Ack removes the job from the processing list.
+func (q *RedisQueue) Ack(ctx context.Context, job *Job) error {
+	return q.conn.LRem(ctx, q.processing, 1, job.raw).Err()
+}
+
+// Close closes the connection to Redis.
+func (q *RedisQueue) Close() error {
+	return q.conn.Close()
+}
@@ -0,0 +1,770 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lildude/strautomagically/internal/strava"
+	"gopkg.in/yaml.v3"
+)
+
+// Expr is a rule condition written in the rules expression language, eg:
+//
+//	type == "Walk" and hour < 9 and elapsed_time >= 1200
+//
+// Expressions support and, or, not and parentheses; the comparisons ==, !=, <, <=, >, >=;
+// regular expression matching with =~ and !~; the string operators contains, startswith
+// and endswith; and list membership with in. Expressions are parsed and type checked when
+// the rules are loaded.
+type Expr struct {
+	src  string
+	root node
+	// details lists the activity details, beyond the activity itself, the expression's fields need.
+	details []string
+}
+
+// SyntaxError reports a malformed expression and where it is in the rules file.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+
+	// scalar is set for errors in unquoted and block scalars, whose lines are trimmed or
+	// joined when they're decoded, until Parse finds the position in the rules file.
+	scalar *scalar
+}
+
+// scalar records where an expression came from in the rules file.
+type scalar struct {
+	src string
+	// line and column are where the expression's content starts in the file.
+	line, column int
+}
+
+// locate converts the error position, relative to the expression, to a position in the
+// rules file lines. Decoding only changes the whitespace in unquoted and block scalars
+// so the error is found by counting the other characters before it.
+func (e *SyntaxError) locate(lines []string) {
+	s := e.scalar
+	e.scalar = nil
+
+	// Count the characters before the error in the expression and whether the error is at one
+	before, at := 0, false
+	line, col := 1, 1
+	for _, r := range s.src {
+		if line == e.Line && col == e.Column {
+			at = !unicode.IsSpace(r)
+			break
+		}
+		if r == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+		if !unicode.IsSpace(r) {
+			before++
+		}
+	}
+
+	// Find the same character in the file, or the one after the last character before the error
+	e.Line, e.Column = s.line, s.column
+	if before == 0 && !at {
+		return
+	}
+	n := 0
+	for line, col = s.line, s.column; line <= len(lines); line, col = line+1, 1 {
+		rs := []rune(lines[line-1])
+		for ; col <= len(rs); col++ {
+			if unicode.IsSpace(rs[col-1]) {
+				continue
+			}
+			if n == before {
+				e.Line, e.Column = line, col
+				return
+			}
+			n++
+			if n == before && !at {
+				e.Line, e.Column = line, col+1
+				return
+			}
+		}
+	}
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// ParseExpr parses and type checks an expression. The positions in any error are relative to the expression.
+func ParseExpr(src string) (*Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	if root.kind() != kindBool {
+		return nil, p.errorf(toks[0], "expression must be a condition, not a %s", root.kind())
+	}
+
+	return &Expr{src: src, root: root, details: p.details}, nil
+}
+
+// UnmarshalYAML parses the expression, converting any error position to a position in the rules file.
+func (e *Expr) UnmarshalYAML(value *yaml.Node) error {
+	var src string
+	if err := value.Decode(&src); err != nil {
+		return err
+	}
+
+	x, err := ParseExpr(src)
+	if err != nil {
+		se, ok := err.(*SyntaxError) //nolint:errorlint // ParseExpr only returns unwrapped syntax errors
+		if !ok {
+			return err
+		}
+		switch value.Style {
+		case yaml.DoubleQuotedStyle, yaml.SingleQuotedStyle:
+			se.Line += value.Line - 1
+			se.Column += value.Column
+		case yaml.LiteralStyle, yaml.FoldedStyle:
+			// The content starts on the line after the block indicator
+			se.scalar = &scalar{src: src, line: value.Line + 1, column: 1}
+		default:
+			se.scalar = &scalar{src: src, line: value.Line, column: value.Column}
+		}
+		return se
+	}
+
+	*e = *x
+	return nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// captures holds the capture groups from regular expressions matched while evaluating an expression.
+type captures struct {
+	match  []string
+	groups map[string]string
+}
+
+// eval reports whether the activity matches the expression. If trace is set, the
+// outcome of each condition evaluated is also returned.
+func (e *Expr) eval(a *strava.Activity, ev Event, trace bool) (bool, *captures, []Condition) {
+	env := &env{activity: a, event: ev, captures: &captures{groups: map[string]string{}}, trace: trace}
+	ok := cond(e.root, env)
+	return ok, env.captures, env.conditions
+}
+
+type env struct {
+	activity   *strava.Activity
+	event      Event
+	captures   *captures
+	trace      bool
+	conditions []Condition
+}
+
+// cond evaluates a boolean node, recording the outcome of comparisons and boolean fields when tracing.
+func cond(n node, e *env) bool {
+	v, _ := n.eval(e).(bool)
+	if e.trace {
+		switch n := n.(type) {
+		case *comparisonNode:
+			e.conditions = append(e.conditions, Condition{Condition: n.String(), Value: n.left.eval(e), Matched: v})
+		case *fieldNode:
+			e.conditions = append(e.conditions, Condition{Condition: n.String(), Value: v, Matched: v})
+		}
+	}
+	return v
+}
+
+// kind is the type of a value in an expression.
+type kind int
+
+const (
+	kindBool kind = iota
+	kindNumber
+	kindString
+	kindRegex
+	kindList
+)
+
+func (k kind) String() string {
+	return [...]string{"boolean", "number", "string", "regular expression", "list"}[k]
+}
+
+// field is an activity attribute that can be used in an expression.
+type field struct {
+	kind kind
+	get  func(*env) any
+}
+
+// fieldDetails maps the fields that need more than the activity itself to the detail they need.
+var fieldDetails = map[string]string{
+	"laps":          DetailLaps,
+	"max_heartrate": DetailStreams,
+	"max_watts":     DetailStreams,
+	"max_cadence":   DetailStreams,
+}
+
+var fields = map[string]field{
+	"athlete":           {kindNumber, func(e *env) any { return float64(e.activity.Athlete.ID) }},
+	"name":              {kindString, func(e *env) any { return e.activity.Name }},
+	"type":              {kindString, func(e *env) any { return e.activity.Type }},
+	"sport_type":        {kindString, func(e *env) any { return e.activity.Sport() }},
+	"device_name":       {kindString, func(e *env) any { return e.activity.DeviceName }},
+	"timezone":          {kindString, func(e *env) any { return e.activity.Timezone }},
+	"description":       {kindString, func(e *env) any { return e.activity.Description }},
+	"external_id":       {kindString, func(e *env) any { return e.activity.ExternalID }},
+	"gear_id":           {kindString, func(e *env) any { return e.activity.GearID }},
+	"distance":          {kindNumber, func(e *env) any { return e.activity.Distance }},
+	"elapsed_time":      {kindNumber, func(e *env) any { return float64(e.activity.ElapsedTime) }},
+	"moving_time":       {kindNumber, func(e *env) any { return float64(e.activity.MovingTime) }},
+	"elevation":         {kindNumber, func(e *env) any { return e.activity.TotalElevationGain }},
+	"average_speed":     {kindNumber, func(e *env) any { return e.activity.AverageSpeed }},
+	"average_watts":     {kindNumber, func(e *env) any { return e.activity.AverageWatts }},
+	"average_heartrate": {kindNumber, func(e *env) any { return e.activity.AverageHeartrate }},
+	"average_cadence":   {kindNumber, func(e *env) any { return e.activity.AverageCadence }},
+	"kudos":             {kindNumber, func(e *env) any { return float64(e.activity.KudosCount) }},
+	"photos":            {kindNumber, func(e *env) any { return float64(e.activity.TotalPhotoCount) }},
+	"workout_type":      {kindNumber, func(e *env) any { return float64(e.activity.WorkoutType) }},
+	"hour":              {kindNumber, func(e *env) any { return float64(e.activity.StartDateLocal.Hour()) }},
+	"month":             {kindNumber, func(e *env) any { return float64(e.activity.StartDateLocal.Month()) }},
+	"weekday":           {kindString, func(e *env) any { return e.activity.StartDateLocal.Weekday().String() }},
+	"commute":           {kindBool, func(e *env) any { return e.activity.Commute }},
+	"hide_from_home":    {kindBool, func(e *env) any { return e.activity.HideFromHome }},
+	"private":           {kindBool, func(e *env) any { return e.activity.Private }},
+	"trainer":           {kindBool, func(e *env) any { return e.activity.Trainer }},
+	"manual":            {kindBool, func(e *env) any { return e.activity.Manual }},
+	"has_map":           {kindBool, func(e *env) any { return e.activity.Map.SummaryPolyline != "" }},
+	// Laps and splits are counted, streams are summarised
+	"laps":          {kindNumber, func(e *env) any { return float64(len(e.activity.Laps)) }},
+	"splits":        {kindNumber, func(e *env) any { return float64(len(e.activity.SplitsMetric)) }},
+	"max_heartrate": {kindNumber, func(e *env) any { return float64(e.activity.Streams.MaxHeartrate()) }},
+	"max_watts":     {kindNumber, func(e *env) any { return float64(e.activity.Streams.MaxWatts()) }},
+	"max_cadence":   {kindNumber, func(e *env) any { return float64(e.activity.Streams.MaxCadence()) }},
+	// The webhook event and, for update events, the fields that were changed
+	"event":           {kindString, func(e *env) any { return e.event.AspectType }},
+	"updates.title":   {kindString, func(e *env) any { return e.event.Updates.Title }},
+	"updates.type":    {kindString, func(e *env) any { return e.event.Updates.Type }},
+	"updates.private": {kindBool, func(e *env) any { return e.event.Updates.Private == "true" }},
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokRegex
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	val  any
+	line int
+	col  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	rs := []rune(src)
+	line, col := 1, 1
+
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		start := token{line: line, col: col}
+		n := 1
+
+		switch {
+		case c == '\n':
+			line, col = line+1, 1
+			i++
+			continue
+		case unicode.IsSpace(c):
+		case c == '(':
+			start.kind = tokLParen
+		case c == ')':
+			start.kind = tokRParen
+		case c == '[':
+			start.kind = tokLBracket
+		case c == ']':
+			start.kind = tokRBracket
+		case c == ',':
+			start.kind = tokComma
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			start.kind = tokOp
+			if i+1 < len(rs) && (rs[i+1] == '=' || (rs[i+1] == '~' && (c == '=' || c == '!'))) {
+				n = 2
+			}
+			if op := string(rs[i : i+n]); op == "=" || op == "!" {
+				return nil, &SyntaxError{Line: line, Column: col, Msg: fmt.Sprintf("unknown operator %q", op)}
+			}
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(rs) && rs[j] != c {
+				if rs[j] == '\\' && c == '"' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				return nil, &SyntaxError{Line: line, Column: col, Msg: "unterminated string"}
+			}
+			n = j - i + 1
+			start.kind = tokString
+			start.val = string(rs[i+1 : j])
+			if c == '"' {
+				s, err := strconv.Unquote(string(rs[i : j+1]))
+				if err != nil {
+					return nil, &SyntaxError{Line: line, Column: col, Msg: "invalid string " + string(rs[i:j+1])}
+				}
+				start.val = s
+			}
+		case c == '/':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != '/'; j++ {
+				// Escaped slashes are part of the pattern and escaped backslashes can't escape the closing slash
+				if rs[j] == '\\' && j+1 < len(rs) && (rs[j+1] == '/' || rs[j+1] == '\\') {
+					if rs[j+1] == '\\' {
+						b.WriteRune(rs[j])
+					}
+					j++
+				}
+				b.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, &SyntaxError{Line: line, Column: col, Msg: "unterminated regular expression"}
+			}
+			flags := ""
+			for j+1 < len(rs) && strings.ContainsRune("ims", rs[j+1]) {
+				flags += string(rs[j+1])
+				j++
+			}
+			pattern := b.String()
+			if flags != "" {
+				pattern = "(?" + flags + ")" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, &SyntaxError{Line: line, Column: col, Msg: fmt.Sprintf("invalid regular expression: %s", err)}
+			}
+			n = j - i + 1
+			start.kind = tokRegex
+			start.val = re
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			f, err := strconv.ParseFloat(string(rs[i:j]), 64)
+			if err != nil {
+				return nil, &SyntaxError{Line: line, Column: col, Msg: "invalid number " + string(rs[i:j])}
+			}
+			n = j - i
+			start.kind = tokNumber
+			start.val = f
+		case c == '_' || unicode.IsLetter(c):
+			j := i + 1
+			for j < len(rs) && (rs[j] == '_' || rs[j] == '.' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			n = j - i
+			start.kind = tokIdent
+		default:
+			return nil, &SyntaxError{Line: line, Column: col, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+
+		if !unicode.IsSpace(c) {
+			start.text = string(rs[i : i+n])
+			toks = append(toks, start)
+		}
+		i += n
+		col += n
+	}
+
+	return append(toks, token{kind: tokEOF, line: line, col: col}), nil
+}
+
+// Parser
+
+type parser struct {
+	toks    []token
+	pos     int
+	details []string
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &SyntaxError{Line: t.line, Column: t.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && t.text == word
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		t := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.logical(t, left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		t := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.logical(t, left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) logical(t token, left, right node) (node, error) {
+	if left.kind() != kindBool || right.kind() != kindBool {
+		return nil, p.errorf(t, "%q needs conditions on both sides", t.text)
+	}
+	return &logicalNode{op: t.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isKeyword("not") {
+		t := p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if x.kind() != kindBool {
+			return nil, p.errorf(t, "\"not\" needs a condition")
+		}
+		return &notNode{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisonKeywords = map[string]bool{"contains": true, "startswith": true, "endswith": true, "in": true}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if t.kind != tokOp && (t.kind != tokIdent || !comparisonKeywords[t.text]) {
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	lk, rk := left.kind(), right.kind()
+	switch t.text {
+	case "==", "!=":
+		if lk != rk || lk == kindList || lk == kindRegex {
+			return nil, p.errorf(t, "cannot compare %s with %s", lk, rk)
+		}
+	case "<", "<=", ">", ">=":
+		if lk != kindNumber || rk != kindNumber {
+			return nil, p.errorf(t, "%q needs numbers on both sides", t.text)
+		}
+	case "=~", "!~":
+		if lk != kindString {
+			return nil, p.errorf(t, "%q needs a string on the left", t.text)
+		}
+		if l, ok := right.(*literalNode); ok && rk == kindString {
+			re, err := regexp.Compile(l.val.(string))
+			if err != nil {
+				return nil, p.errorf(t, "invalid regular expression: %s", err)
+			}
+			right, rk = &literalNode{val: re, k: kindRegex, text: l.text}, kindRegex
+		}
+		if rk != kindRegex {
+			return nil, p.errorf(t, "%q needs a regular expression on the right", t.text)
+		}
+	case "contains", "startswith", "endswith":
+		if lk != kindString || rk != kindString {
+			return nil, p.errorf(t, "%q needs strings on both sides", t.text)
+		}
+	case "in":
+		l, ok := right.(*listNode)
+		if !ok {
+			return nil, p.errorf(t, "\"in\" needs a list on the right")
+		}
+		for _, v := range l.items {
+			if v.kind() != lk {
+				return nil, p.errorf(t, "cannot compare %s with %s in list", lk, v.kind())
+			}
+		}
+	}
+
+	return &comparisonNode{op: t.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return &literalNode{val: t.val, k: kindString, text: t.text}, nil
+	case tokNumber:
+		return &literalNode{val: t.val, k: kindNumber, text: t.text}, nil
+	case tokRegex:
+		return &literalNode{val: t.val, k: kindRegex, text: t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return &literalNode{val: t.text == "true", k: kindBool, text: t.text}, nil
+		case "and", "or", "not", "in", "contains", "startswith", "endswith":
+			return nil, p.errorf(t, "unexpected %s", t)
+		}
+		f, ok := fields[t.text]
+		if !ok {
+			return nil, p.errorf(t, "unknown field %s", t)
+		}
+		if d, ok := fieldDetails[t.text]; ok && !contains(p.details, d) {
+			p.details = append(p.details, d)
+		}
+		return &fieldNode{name: t.text, field: f}, nil
+	case tokLParen:
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.kind != tokRParen {
+			return nil, p.errorf(c, "expected \")\" but found %s", c)
+		}
+		return &parenNode{x: x}, nil
+	case tokLBracket:
+		l := &listNode{}
+		for p.peek().kind != tokRBracket {
+			if len(l.items) > 0 {
+				if c := p.next(); c.kind != tokComma {
+					return nil, p.errorf(c, "expected \",\" or \"]\" but found %s", c)
+				}
+			}
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := item.(*literalNode); !ok {
+				return nil, p.errorf(t, "lists may only contain strings and numbers")
+			}
+			l.items = append(l.items, item)
+		}
+		p.next()
+		return l, nil
+	}
+	return nil, p.errorf(t, "unexpected %s", t)
+}
+
+// AST
+
+type node interface {
+	kind() kind
+	eval(e *env) any
+	String() string
+}
+
+type literalNode struct {
+	val  any
+	k    kind
+	text string
+}
+
+func (n *literalNode) kind() kind      { return n.k }
+func (n *literalNode) eval(_ *env) any { return n.val }
+func (n *literalNode) String() string  { return n.text }
+
+type fieldNode struct {
+	name  string
+	field field
+}
+
+func (n *fieldNode) kind() kind      { return n.field.kind }
+func (n *fieldNode) eval(e *env) any { return n.field.get(e) }
+func (n *fieldNode) String() string  { return n.name }
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) kind() kind { return kindList }
+func (n *listNode) String() string {
+	items := make([]string, len(n.items))
+	for i, item := range n.items {
+		items[i] = item.String()
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+func (n *listNode) eval(e *env) any {
+	vals := make([]any, len(n.items))
+	for i, item := range n.items {
+		vals[i] = item.eval(e)
+	}
+	return vals
+}
+
+type parenNode struct {
+	x node
+}
+
+func (n *parenNode) kind() kind { return n.x.kind() }
+func (n *parenNode) eval(e *env) any {
+	if n.x.kind() == kindBool {
+		return cond(n.x, e)
+	}
+	return n.x.eval(e)
+}
+func (n *parenNode) String() string { return "(" + n.x.String() + ")" }
+
+type notNode struct {
+	x node
+}
+
+func (n *notNode) kind() kind     { return kindBool }
+func (n *notNode) String() string { return "not " + n.x.String() }
+func (n *notNode) eval(e *env) any {
+	return !cond(n.x, e)
+}
+
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n *logicalNode) kind() kind     { return kindBool }
+func (n *logicalNode) String() string { return n.left.String() + " " + n.op + " " + n.right.String() }
+func (n *logicalNode) eval(e *env) any {
+	l := cond(n.left, e)
+	if n.op == "and" && !l {
+		return false
+	}
+	if n.op == "or" && l {
+		return true
+	}
+	return cond(n.right, e)
+}
+
+type comparisonNode struct {
+	op          string
+	left, right node
+}
+
+func (n *comparisonNode) kind() kind { return kindBool }
+func (n *comparisonNode) String() string {
+	return n.left.String() + " " + n.op + " " + n.right.String()
+}
+func (n *comparisonNode) eval(e *env) any {
+	l, r := n.left.eval(e), n.right.eval(e)
+
+	switch n.op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	case "<":
+		return l.(float64) < r.(float64)
+	case "<=":
+		return l.(float64) <= r.(float64)
+	case ">":
+		return l.(float64) > r.(float64)
+	case ">=":
+		return l.(float64) >= r.(float64)
+	case "=~", "!~":
+		re, _ := r.(*regexp.Regexp)
+		s, _ := l.(string)
+		m := re.FindStringSubmatch(s)
+		if n.op == "!~" {
+			return m == nil
+		}
+		if m == nil {
+			return false
+		}
+		e.captures.match = m
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				e.captures.groups[name] = m[i]
+			}
+		}
+		return true
+	case "contains":
+		return strings.Contains(l.(string), r.(string))
+	case "startswith":
+		return strings.HasPrefix(l.(string), r.(string))
+	case "endswith":
+		return strings.HasSuffix(l.(string), r.(string))
+	case "in":
+		for _, v := range r.([]any) {
+			if v == l {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
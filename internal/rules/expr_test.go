@@ -0,0 +1,196 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"unknown field", `type == "Walk" and colour == "red"`, `line 1, column 20: unknown field "colour"`},
+		{"mismatched types", `type == 5`, `line 1, column 6: cannot compare string with number`},
+		{"numeric comparison on string", `name > "A"`, `line 1, column 6: ">" needs numbers on both sides`},
+		{"regex on number", `distance =~ /5k/`, `line 1, column 10: "=~" needs a string on the left`},
+		{"invalid regex", `name =~ /(/`, "line 1, column 9: invalid regular expression: error parsing regexp: missing closing ): `(`"},
+		{"invalid regex string", `name =~ "("`, "line 1, column 6: invalid regular expression: error parsing regexp: missing closing ): `(`"},
+		{"not a condition", `distance`, `line 1, column 1: expression must be a condition, not a number`},
+		{"and needs conditions", `commute and distance`, `line 1, column 9: "and" needs conditions on both sides`},
+		{"unterminated string", `name == "foo`, `line 1, column 9: unterminated string`},
+		{"unbalanced parens", `(commute or trainer`, `line 1, column 20: expected ")" but found end of expression`},
+		{"trailing tokens", `commute trainer`, `line 1, column 9: unexpected "trainer"`},
+		{"unknown operator", `name = "foo"`, `line 1, column 6: unknown operator "="`},
+		{"in needs a list", `type in "Ride"`, `line 1, column 6: "in" needs a list on the right`},
+		{"mixed list", `type in ["Ride", 5]`, `line 1, column 6: cannot compare string with number in list`},
+		{"multiline position", "commute and\n  hour < \"9\"", `line 2, column 8: "<" needs numbers on both sides`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseExpr(tc.expr)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if err.Error() != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	a := &strava.Activity{
+		Name:               "8x500m/3:30r row",
+		Type:               "Rowing",
+		SportType:          "Rowing",
+		DeviceName:         "Concept2 PM5",
+		MovingTime:         1750,
+		AverageWatts:       212.4,
+		AverageHeartrate:   151.2,
+		KudosCount:         3,
+		Timezone:           "(GMT+00:00) Europe/London",
+		Description:        "Intervals\nhttps://app.erg.zone",
+		ExternalID:         "trainerroad-1234",
+		Distance:           5000,
+		ElapsedTime:        1800,
+		TotalElevationGain: 12.5,
+		Commute:            true,
+		StartDateLocal:     time.Date(2022, 7, 16, 7, 30, 0, 0, time.UTC), // A Saturday
+		Laps:               make([]strava.Lap, 16),
+		SplitsMetric:       make([]strava.Split, 5),
+		Streams:            &strava.Streams{Heartrate: []int{120, 176, 150}, Watts: []int{180, 310}},
+	}
+	ev := Event{AspectType: EventUpdate, Updates: strava.Updates{Title: "8x500m/3:30r row", Private: "true"}}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`type == "Rowing"`, true},
+		{`type != "Rowing"`, false},
+		{`distance >= 5000 and elapsed_time < 3600`, true},
+		{`elevation > 10 and elevation <= 12.5`, true},
+		{`hour < 9 and weekday in ["Saturday", "Sunday"]`, true},
+		{`month == 7`, true},
+		{`commute and not trainer`, true},
+		{`trainer or (commute and private)`, false},
+		{`not (type == "Walk" or type == "Run")`, true},
+		{`external_id startswith "trainerroad"`, true},
+		{`name endswith "row"`, true},
+		{`description contains "app.erg.zone"`, true},
+		{`name =~ /^\d+x\d+m/`, true},
+		{`name =~ /ROW$/i`, true},
+		{`name =~ /\d+m\/3/`, true},
+		{`name !~ /row\\/`, true},
+		{`name !~ "walk"`, true},
+		{`commute == true`, true},
+		{`type in []`, false},
+		{`event == "update" and updates.title == name`, true},
+		{`updates.private and updates.type == ""`, true},
+		{`laps >= 16 and splits == 5`, true},
+		{`max_heartrate > 175 and max_watts >= 300`, true},
+		{`max_cadence > 0`, false},
+		{`sport_type == "Rowing" and device_name startswith "Concept2"`, true},
+		{`moving_time < elapsed_time and average_watts > 200 and average_heartrate < 160`, true},
+		{`kudos >= 3 and photos == 0`, true},
+		{`timezone endswith "London" and not manual and not has_map`, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			x, err := ParseExpr(tc.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, _, _ := x.eval(a, ev, false)
+			if got != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseExprPositionInFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  string
+	}{
+		{
+			"plain",
+			"rules:\n  - name: Walk\n    when: type == \"Walk\" and colour\n",
+			`line 3, column 30: unknown field "colour"`,
+		},
+		{
+			"quoted",
+			"rules:\n  - name: Walk\n    when: 'type == \"Walk\" and colour'\n",
+			`line 3, column 31: unknown field "colour"`,
+		},
+		{
+			"block",
+			"rules:\n  - name: Walk\n    when: |\n      type == \"Walk\" and\n      colour\n",
+			`line 5, column 7: unknown field "colour"`,
+		},
+		{
+			"multiline plain",
+			"rules:\n  - name: Walk\n    when: type == \"Walk\" and\n      hour < 9 and\n      colour\n",
+			`line 5, column 7: unknown field "colour"`,
+		},
+		{
+			"folded",
+			"rules:\n  - name: Walk\n    when: >\n      type == \"Walk\" and\n      hour < 9 and colour\n",
+			`line 5, column 20: unknown field "colour"`,
+		},
+		{
+			"folded end of expression",
+			"rules:\n  - name: Walk\n    when: >-\n      (commute or\n        trainer\n",
+			`line 5, column 16: expected ")" but found end of expression`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(tc.rules))
+			var se *SyntaxError
+			if !errors.As(err, &se) {
+				t.Fatalf("expected syntax error, got %v", err)
+			}
+			if se.Error() != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, se)
+			}
+		})
+	}
+}
+
+func TestWhenCapturesInName(t *testing.T) {
+	rs, err := Parse([]byte(`
+rules:
+  - name: Intervals
+    when: type == "Rowing" and name =~ /^(?P<reps>\d+)x(\d+)m/
+    set:
+      name: "{{ .Groups.reps }}x {{ index .Match 2 }}m Row"
+  - name: Fallback
+    set:
+      name: Row
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, _ := rs.Apply(context.Background(), &strava.Activity{Type: "Rowing", Name: "8x500m/3:30r row"}, nil)
+	if got.Update.Name != "8x 500m Row" {
+		t.Errorf("expected name %q, got %q", "8x 500m Row", got.Update.Name)
+	}
+
+	got, _ = rs.Apply(context.Background(), &strava.Activity{Type: "Rowing", Name: "5:00 row"}, nil)
+	if got.Update.Name != "Row" {
+		t.Errorf("expected name %q, got %q", "Row", got.Update.Name)
+	}
+}
@@ -0,0 +1,194 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+// goldenExt is the extension of the golden files holding the update expected for each test activity.
+const goldenExt = ".golden.json"
+
+// TestCase pairs an activity with the golden file holding the update the rules should make to it.
+type TestCase struct {
+	Name     string
+	Activity string
+	Golden   string
+}
+
+// TestResult holds the outcome of running the rules against a test case.
+type TestResult struct {
+	Name string
+	// Diff is a line diff from the golden update to the update the rules made. It's empty if they match.
+	Diff string
+	// Updated reports whether the golden file was written.
+	Updated bool
+	Err     error
+}
+
+// Failed reports whether the test case failed.
+func (r TestResult) Failed() bool {
+	return r.Err != nil || r.Diff != ""
+}
+
+// FindTests returns the test cases in dir. Every activity JSON file in the directory, or
+// its subdirectories, is a test case with a golden file of the same name ending in .golden.json.
+func FindTests(dir string) ([]TestCase, error) {
+	var cases []TestCase
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") || strings.HasSuffix(path, goldenExt) {
+			return nil
+		}
+		name, _ := filepath.Rel(dir, strings.TrimSuffix(path, ".json"))
+		cases = append(cases, TestCase{
+			Name:     filepath.ToSlash(name),
+			Activity: path,
+			Golden:   strings.TrimSuffix(path, ".json") + goldenExt,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("finding tests: %w", err)
+	}
+
+	sort.Slice(cases, func(i, j int) bool { return cases[i].Name < cases[j].Name })
+	return cases, nil
+}
+
+// RunTests runs the rules against every test case in dir and compares the update
+// with the golden file. The golden files are rewritten with the update the rules
+// made instead if regenerate is set. Weather isn't added and calendar lookups are
+// skipped so the tests don't depend on external services.
+func (rs *RuleSet) RunTests(ctx context.Context, dir string, regenerate bool) ([]TestResult, error) {
+	cases, err := FindTests(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]TestResult, 0, len(cases))
+	for _, tc := range cases {
+		results = append(results, rs.runTest(ctx, tc, regenerate))
+	}
+	return results, nil
+}
+
+func (rs *RuleSet) runTest(ctx context.Context, tc TestCase, regenerate bool) TestResult {
+	result := TestResult{Name: tc.Name}
+
+	data, err := os.ReadFile(tc.Activity)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	var a strava.Activity
+	if err := json.Unmarshal(data, &a); err != nil {
+		result.Err = fmt.Errorf("parsing activity: %w", err)
+		return result
+	}
+
+	res, err := rs.Apply(ctx, &a, nil)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	got, err := marshalUpdate(&res.Update)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if regenerate {
+		if err := os.WriteFile(tc.Golden, got, 0o600); err != nil {
+			result.Err = err
+			return result
+		}
+		result.Updated = true
+		return result
+	}
+
+	want, err := os.ReadFile(tc.Golden)
+	if errors.Is(err, fs.ErrNotExist) {
+		result.Err = fmt.Errorf("missing golden file %s, run with -update to create it", tc.Golden)
+		return result
+	}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	// Compare the decoded updates so formatting changes to the golden file don't cause failures
+	var wantUpdate strava.UpdatableActivity
+	if err := json.Unmarshal(want, &wantUpdate); err != nil {
+		result.Err = fmt.Errorf("parsing golden file: %w", err)
+		return result
+	}
+	if !reflect.DeepEqual(wantUpdate, res.Update) {
+		want, _ = marshalUpdate(&wantUpdate)
+		result.Diff = diffLines(string(want), string(got))
+	}
+	return result
+}
+
+func marshalUpdate(ua *strava.UpdatableActivity) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(ua); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// diffLines returns a unified style line diff of want and got, without hunk headers.
+// Lines only in want are prefixed with "-", lines only in got with "+".
+func diffLines(want, got string) string {
+	a := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		default:
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		}
+	}
+	return sb.String()
+}
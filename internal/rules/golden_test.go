@@ -0,0 +1,106 @@
+package rules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRuleTests runs the rule tests against the default rules file.
+func TestRuleTests(t *testing.T) {
+	rs, err := Load("../../rules.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error loading default rules: %v", err)
+	}
+
+	results, err := rs.RunTests(context.Background(), "../../ruletests", false)
+	if err != nil {
+		t.Fatalf("unexpected error running rule tests: %v", err)
+	}
+	if len(results) == 0 {
+		t.Fatal("expected rule tests, got none")
+	}
+	for _, res := range results {
+		if res.Err != nil {
+			t.Errorf("%s: unexpected error: %v", res.Name, res.Err)
+		}
+		if res.Diff != "" {
+			t.Errorf("%s: update doesn't match golden file:\n%s", res.Name, res.Diff)
+		}
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	rs, err := Parse([]byte(`
+rules:
+  - name: Walk
+    match:
+      type: Walk
+    set:
+      name: Walk
+      hide_from_home: true
+`))
+	if err != nil {
+		t.Fatalf("unexpected error parsing rules: %v", err)
+	}
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("walk.json", `{"type": "Walk", "name": "Morning Walk"}`)
+	write("walk.golden.json", `{"name": "Walk", "hide_from_home": true}`)
+	write("run.json", `{"type": "Run"}`)
+	write("run.golden.json", `{"name": "Run"}`)
+	write("ride.json", `{"type": "Ride"}`)
+	write("invalid.json", `{"type": `)
+
+	results, err := rs.RunTests(context.Background(), dir, false)
+	if err != nil {
+		t.Fatalf("unexpected error running tests: %v", err)
+	}
+
+	got := map[string]TestResult{}
+	for _, res := range results {
+		got[res.Name] = res
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 tests, got %d", len(got))
+	}
+	if got["walk"].Failed() {
+		t.Errorf("expected walk to pass, got %+v", got["walk"])
+	}
+	if want := "- {\n-   \"name\": \"Run\"\n- }\n+ {}\n"; got["run"].Diff != want {
+		t.Errorf("expected run diff %q, got %q", want, got["run"].Diff)
+	}
+	if err := got["ride"].Err; err == nil || !strings.Contains(err.Error(), "missing golden file") {
+		t.Errorf("expected missing golden file error, got %v", err)
+	}
+	if err := got["invalid"].Err; err == nil || !strings.Contains(err.Error(), "parsing activity") {
+		t.Errorf("expected parsing error, got %v", err)
+	}
+
+	// Regenerating fixes the failures that aren't errors
+	if _, err := rs.RunTests(context.Background(), dir, true); err != nil {
+		t.Fatalf("unexpected error regenerating golden files: %v", err)
+	}
+	results, _ = rs.RunTests(context.Background(), dir, false)
+	for _, res := range results {
+		if res.Failed() && res.Name != "invalid" {
+			t.Errorf("expected %s to pass after regenerating, got %+v", res.Name, res)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines("a\nb\nc\n", "a\nc\nd\n")
+	want := "  a\n- b\n  c\n+ d\n"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
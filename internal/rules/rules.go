@@ -0,0 +1,661 @@
+// Package rules implements the declarative rules used to update Strava activities.
+package rules
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/lildude/strautomagically/internal/calendarevent"
+	"github.com/lildude/strautomagically/internal/strava"
+	"gopkg.in/yaml.v3"
+)
+
+// Conflict policies decide what happens when more than one rule sets the same field to different values.
+const (
+	PolicyFirstWins = "first-wins"
+	PolicyLastWins  = "last-wins"
+	PolicyError     = "error"
+)
+
+// Webhook events rules can be applied on.
+const (
+	EventCreate = "create"
+	EventUpdate = "update"
+)
+
+// Activity details that aren't part of the activity and cost a request each to fetch.
+const (
+	DetailLaps    = "laps"
+	DetailStreams = "streams"
+)
+
+// Event describes the webhook event the rules are being applied for.
+type Event struct {
+	// AspectType is EventCreate or EventUpdate.
+	AspectType string
+	// Updates holds the fields changed by an update event.
+	Updates strava.Updates
+}
+
+// RuleSet holds the rules loaded from the rules file.
+type RuleSet struct {
+	// Gear maps friendly names to Strava gear IDs so rules can refer to "bike" rather than "b10013574".
+	Gear map[string]string `yaml:"gear"`
+	// Conflicts is the policy used when rules set the same field to different values. Defaults to PolicyFirstWins.
+	Conflicts string `yaml:"conflicts"`
+	// Fetch lists the activity details to fetch for the rules' templates: DetailLaps and,
+	// or, DetailStreams. Details used by when expressions are fetched without being listed.
+	Fetch stringList `yaml:"fetch"`
+	Rules []*Rule    `yaml:"rules"`
+}
+
+// ConflictError is returned by Apply when rules conflict and the rule set's policy is PolicyError.
+type ConflictError struct {
+	Field string
+	Rules [2]string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("rules %q and %q both set %s", e.Rules[0], e.Rules[1], e.Field)
+}
+
+// Rule pairs the conditions an activity must match with the changes to make to it.
+type Rule struct {
+	Name  string `yaml:"name"`
+	Match Match  `yaml:"match"`
+	// When is an optional expression the activity must also match.
+	When *Expr   `yaml:"when"`
+	Set  Actions `yaml:"set"`
+	// Priority orders the rules. Rules with a higher priority are applied first and
+	// rules with the same priority are applied in the order they appear in the file.
+	Priority int `yaml:"priority"`
+	// Stop prevents any later rules from being applied if this rule matches.
+	Stop bool `yaml:"stop"`
+	// On lists the webhook events the rule is applied on. Defaults to EventCreate only.
+	On stringList `yaml:"on"`
+
+	name        *template.Template
+	description *template.Template
+}
+
+// Match holds the conditions an activity must meet for a rule to apply.
+// All conditions that are set must be met.
+type Match struct {
+	// Athlete limits the rule to the activities of the athletes with these IDs.
+	Athlete intList `yaml:"athlete"`
+	// Type is the deprecated activity type, eg Ride. SportType is more specific, eg
+	// MountainBikeRide, and falls back to the type for activities that don't have one.
+	Type                stringList `yaml:"type"`
+	SportType           stringList `yaml:"sport_type"`
+	Name                stringList `yaml:"name"`
+	ExternalIDPrefix    string     `yaml:"external_id_prefix"`
+	ElapsedTime         Range      `yaml:"elapsed_time"`
+	StartHour           Range      `yaml:"start_hour"`
+	DescriptionContains string     `yaml:"description_contains"`
+}
+
+// Range is an inclusive range. Either end may be omitted.
+type Range struct {
+	Min *int64 `yaml:"min"`
+	Max *int64 `yaml:"max"`
+}
+
+// Actions holds the changes to make to a matching activity.
+type Actions struct {
+	// Name is a text/template executed against the activity. The capture groups from regular
+	// expressions matched by the rule's condition are available as .Match and .Groups.
+	Name string `yaml:"name"`
+	// Description replaces the activity description. It's a template like Name. Weather is
+	// appended to it if enabled.
+	Description string `yaml:"description"`
+	// GearID is a Strava gear ID or a name from the rule set's gear map.
+	GearID string `yaml:"gear_id"`
+	// SportType takes precedence over Type, which is deprecated, if both are set.
+	Type         string        `yaml:"type"`
+	SportType    string        `yaml:"sport_type"`
+	Commute      *bool         `yaml:"commute"`
+	HideFromHome *bool         `yaml:"hide_from_home"`
+	Private      *bool         `yaml:"private"`
+	Trainer      *bool         `yaml:"trainer"`
+	WithPet      *bool         `yaml:"with_pet"`
+	CalendarName *CalendarName `yaml:"calendar_name"`
+	Weather      *bool         `yaml:"weather"`
+}
+
+// CalendarName sets the activity name from the TrainerRoad calendar event at the start of the activity.
+type CalendarName struct {
+	// Prefix is prepended to the event summary. Activities whose name already
+	// starts with the prefix are assumed to have been named already.
+	Prefix string `yaml:"prefix"`
+}
+
+// Result holds the outcome of applying a rule set to an activity.
+type Result struct {
+	Update strava.UpdatableActivity
+	// Rules holds the names of the rules that were applied in the order they were applied.
+	Rules []string
+	// Weather reports whether weather information should be added to the activity.
+	Weather bool
+	// Trace holds the trace of every rule when the result is from Explain.
+	Trace []RuleTrace
+}
+
+// RuleTrace records how a rule was evaluated against an activity.
+type RuleTrace struct {
+	Rule       string      `json:"rule"`
+	Matched    bool        `json:"matched"`
+	Applied    bool        `json:"applied"`
+	Conditions []Condition `json:"conditions"`
+	Changes    []string    `json:"changes,omitempty"`
+	Note       string      `json:"note,omitempty"`
+}
+
+// Condition records the outcome of a single condition. Value is the activity's value
+// for the field being tested. Conditions in a when expression that weren't evaluated
+// because of short-circuiting are not recorded.
+type Condition struct {
+	Condition string `json:"condition"`
+	Value     any    `json:"value"`
+	Matched   bool   `json:"matched"`
+}
+
+// templateData is passed to name and description templates.
+type templateData struct {
+	*strava.Activity
+	Match  []string
+	Groups map[string]string
+}
+
+// stringList allows a condition to be given as a single string or a list of strings.
+type stringList []string
+
+func (s *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = stringList{value.Value}
+		return nil
+	}
+	var l []string
+	if err := value.Decode(&l); err != nil {
+		return err
+	}
+	*s = l
+	return nil
+}
+
+// intList allows a condition to be given as a single number or a list of numbers.
+type intList []int64
+
+func (l *intList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var i int64
+		if err := value.Decode(&i); err != nil {
+			return err
+		}
+		*l = intList{i}
+		return nil
+	}
+	var ints []int64
+	if err := value.Decode(&ints); err != nil {
+		return err
+	}
+	*l = ints
+	return nil
+}
+
+func (l intList) String() string {
+	s := make([]string, len(l))
+	for i, v := range l {
+		s[i] = strconv.FormatInt(v, 10)
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
+
+var funcs = template.FuncMap{
+	"firstLine": func(s string) string {
+		first, _, _ := strings.Cut(s, "\n")
+		return first
+	},
+}
+
+// Load reads and validates the rules file at path.
+func Load(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rules file: %w", err)
+	}
+
+	rs, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing rules file %s: %w", path, err)
+	}
+	return rs, nil
+}
+
+// Parse parses and validates the YAML rules in data.
+func Parse(data []byte) (*RuleSet, error) {
+	rs := &RuleSet{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(rs); err != nil && !errors.Is(err, io.EOF) {
+		var se *SyntaxError
+		if errors.As(err, &se) && se.scalar != nil {
+			se.locate(strings.Split(string(data), "\n"))
+		}
+		return nil, err
+	}
+
+	switch rs.Conflicts {
+	case "":
+		rs.Conflicts = PolicyFirstWins
+	case PolicyFirstWins, PolicyLastWins, PolicyError:
+	default:
+		return nil, fmt.Errorf("unknown conflicts policy %q: must be one of %s, %s or %s", rs.Conflicts, PolicyFirstWins, PolicyLastWins, PolicyError)
+	}
+
+	for _, d := range rs.Fetch {
+		if d != DetailLaps && d != DetailStreams {
+			return nil, fmt.Errorf("unknown detail to fetch %q: must be %s or %s", d, DetailLaps, DetailStreams)
+		}
+	}
+
+	seen := make(map[string]bool, len(rs.Rules))
+	for i, r := range rs.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule %d: missing name", i+1)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("rule %q: duplicate name", r.Name)
+		}
+		seen[r.Name] = true
+
+		for _, on := range r.On {
+			if on != EventCreate && on != EventUpdate {
+				return nil, fmt.Errorf("rule %q: unknown event %q: must be %s or %s", r.Name, on, EventCreate, EventUpdate)
+			}
+		}
+		if len(r.On) == 0 {
+			r.On = stringList{EventCreate}
+		}
+
+		if r.Set.Name != "" {
+			t, err := template.New(r.Name).Funcs(funcs).Parse(r.Set.Name)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: parsing name template: %w", r.Name, err)
+			}
+			r.name = t
+		}
+		if r.Set.Description != "" {
+			t, err := template.New(r.Name).Funcs(funcs).Parse(r.Set.Description)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: parsing description template: %w", r.Name, err)
+			}
+			r.description = t
+		}
+	}
+
+	sort.SliceStable(rs.Rules, func(i, j int) bool {
+		return rs.Rules[i].Priority > rs.Rules[j].Priority
+	})
+
+	return rs, nil
+}
+
+// HandlesUpdates reports whether any of the rules are applied on update events.
+func (rs *RuleSet) HandlesUpdates() bool {
+	if rs == nil {
+		return false
+	}
+	for _, r := range rs.Rules {
+		if contains(r.On, EventUpdate) {
+			return true
+		}
+	}
+	return false
+}
+
+// Needs reports whether the rules need the activity detail, either because it's listed in
+// Fetch or a when expression uses it.
+func (rs *RuleSet) Needs(detail string) bool {
+	if rs == nil {
+		return false
+	}
+	if contains(rs.Fetch, detail) {
+		return true
+	}
+	for _, r := range rs.Rules {
+		if r.When != nil && contains(r.When.details, detail) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply applies every rule that matches the new activity, in priority order, until a
+// matching rule with Stop set is reached. Conflicting changes are resolved using the
+// rule set's conflicts policy. The calendar is only consulted by rules that set the
+// name from a calendar event.
+func (rs *RuleSet) Apply(ctx context.Context, a *strava.Activity, cal calendarevent.CalendarEventGetter) (*Result, error) {
+	return rs.ApplyEvent(ctx, a, Event{AspectType: EventCreate}, cal)
+}
+
+// ApplyEvent applies the rules like Apply for the webhook event. Only rules that
+// are applied on the event's aspect type are considered.
+func (rs *RuleSet) ApplyEvent(ctx context.Context, a *strava.Activity, ev Event, cal calendarevent.CalendarEventGetter) (*Result, error) {
+	res, err := rs.apply(ctx, a, ev, cal, false)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Explain applies the rules like Apply but also records a trace of every rule and the
+// outcome of each of its conditions in the result. Rules after a stop are still
+// evaluated for the trace but not applied. The result is returned even if the rules conflict.
+func (rs *RuleSet) Explain(ctx context.Context, a *strava.Activity, cal calendarevent.CalendarEventGetter) (*Result, error) {
+	return rs.ExplainEvent(ctx, a, Event{AspectType: EventCreate}, cal)
+}
+
+// ExplainEvent explains the rules like Explain for the webhook event.
+func (rs *RuleSet) ExplainEvent(ctx context.Context, a *strava.Activity, ev Event, cal calendarevent.CalendarEventGetter) (*Result, error) {
+	return rs.apply(ctx, a, ev, cal, true)
+}
+
+func (rs *RuleSet) apply(ctx context.Context, a *strava.Activity, ev Event, cal calendarevent.CalendarEventGetter, explain bool) (*Result, error) {
+	res := &Result{Weather: true}
+	if rs == nil {
+		return res, nil
+	}
+
+	// setBy records which rule set each field
+	setBy := map[string]string{}
+	stoppedBy := ""
+	for _, r := range rs.Rules {
+		if stoppedBy != "" && !explain {
+			break
+		}
+
+		var conds []Condition
+		// Only mention the event when it matters, ie the rule isn't a plain create rule or this isn't a create event
+		if ev.AspectType != EventCreate || len(r.On) != 1 || r.On[0] != EventCreate {
+			conds = append(conds, Condition{Condition: "event in " + quoteList(r.On), Value: ev.AspectType, Matched: contains(r.On, ev.AspectType)})
+		}
+		conds = append(conds, r.Match.conditions(a)...)
+		matched := true
+		for _, c := range conds {
+			matched = matched && c.Matched
+		}
+		caps := &captures{}
+		if r.When != nil && (matched || explain) {
+			var ok bool
+			var whenConds []Condition
+			ok, caps, whenConds = r.When.eval(a, ev, explain)
+			conds = append(conds, whenConds...)
+			matched = matched && ok
+		}
+
+		var rt *RuleTrace
+		if explain {
+			res.Trace = append(res.Trace, RuleTrace{Rule: r.Name, Matched: matched, Conditions: conds})
+			rt = &res.Trace[len(res.Trace)-1]
+		}
+		if !matched {
+			continue
+		}
+		if stoppedBy != "" {
+			rt.Note = fmt.Sprintf("not applied: stopped by rule %q", stoppedBy)
+			continue
+		}
+
+		for _, c := range r.changes(ctx, rs, a, cal, caps) {
+			if prev, ok := setBy[c.field]; ok && prev != r.Name && c.value != res.get(c.field) {
+				switch rs.Conflicts {
+				case PolicyFirstWins:
+					if rt != nil {
+						rt.Changes = append(rt.Changes, fmt.Sprintf("%s ignored, already set by rule %q", c, prev))
+					}
+					continue
+				case PolicyError:
+					return res, &ConflictError{Field: c.field, Rules: [2]string{prev, r.Name}}
+				}
+			}
+			setBy[c.field] = r.Name
+			res.set(c.field, c.value)
+			if rt != nil {
+				rt.Changes = append(rt.Changes, c.String())
+			}
+		}
+		res.Rules = append(res.Rules, r.Name)
+		if rt != nil {
+			rt.Applied = true
+		}
+
+		if r.Stop {
+			stoppedBy = r.Name
+		}
+	}
+
+	return res, nil
+}
+
+// conditions returns the outcome of each of the conditions that are set.
+func (m *Match) conditions(a *strava.Activity) []Condition {
+	var conds []Condition
+	add := func(cond string, value any, matched bool) {
+		conds = append(conds, Condition{Condition: cond, Value: value, Matched: matched})
+	}
+
+	if len(m.Athlete) > 0 {
+		add("athlete in "+m.Athlete.String(), a.Athlete.ID, slices.Contains(m.Athlete, a.Athlete.ID))
+	}
+	if len(m.Type) > 0 {
+		add("type in "+quoteList(m.Type), a.Type, contains(m.Type, a.Type))
+	}
+	if len(m.SportType) > 0 {
+		add("sport_type in "+quoteList(m.SportType), a.Sport(), contains(m.SportType, a.Sport()))
+	}
+	if len(m.Name) > 0 {
+		add("name in "+quoteList(m.Name), a.Name, contains(m.Name, a.Name))
+	}
+	if m.ExternalIDPrefix != "" {
+		add(fmt.Sprintf("external_id startswith %q", m.ExternalIDPrefix), a.ExternalID, strings.HasPrefix(a.ExternalID, m.ExternalIDPrefix))
+	}
+	m.ElapsedTime.conditions("elapsed_time", a.ElapsedTime, add)
+	m.StartHour.conditions("start_hour", int64(a.StartDateLocal.Hour()), add)
+	if m.DescriptionContains != "" {
+		add(fmt.Sprintf("description contains %q", m.DescriptionContains), a.Description, strings.Contains(a.Description, m.DescriptionContains))
+	}
+
+	return conds
+}
+
+func (r Range) conditions(name string, v int64, add func(string, any, bool)) {
+	if r.Min != nil {
+		add(fmt.Sprintf("%s >= %d", name, *r.Min), v, v >= *r.Min)
+	}
+	if r.Max != nil {
+		add(fmt.Sprintf("%s <= %d", name, *r.Max), v, v <= *r.Max)
+	}
+}
+
+// quoteList formats the list like a list in a when expression.
+func quoteList(list []string) string {
+	q := make([]string, len(list))
+	for i, s := range list {
+		q[i] = strconv.Quote(s)
+	}
+	return "[" + strings.Join(q, ", ") + "]"
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// change is a single field change made by a rule.
+type change struct {
+	field string
+	value any
+}
+
+func (c change) String() string {
+	if s, ok := c.value.(string); ok {
+		return fmt.Sprintf("%s: %q", c.field, s)
+	}
+	return fmt.Sprintf("%s: %v", c.field, c.value)
+}
+
+// changes returns the changes the rule makes to the activity.
+func (r *Rule) changes(ctx context.Context, rs *RuleSet, a *strava.Activity, cal calendarevent.CalendarEventGetter, caps *captures) []change {
+	var cs []change
+	set := r.Set
+
+	data := templateData{Activity: a, Match: caps.match, Groups: caps.groups}
+	if r.name != nil {
+		var b bytes.Buffer
+		if err := r.name.Execute(&b, data); err != nil {
+			slog.Error("unable to execute name template", "rule", r.Name, "error", err)
+		} else if b.String() != a.Name {
+			cs = append(cs, change{"name", b.String()})
+		}
+	}
+
+	if set.CalendarName != nil && cal != nil && !strings.HasPrefix(a.Name, set.CalendarName.Prefix) {
+		event, err := cal.GetCalendarEvent(ctx, a.StartDate)
+		if err != nil {
+			slog.Error("unable to get TrainerRoad calendar event", "error", err)
+		}
+
+		if event != nil && event.Summary != "" {
+			slog.Info("found TrainerRoad calendar event", "summary", event.Summary) //nolint:gosec // G706 noise
+			cs = append(cs, change{"name", set.CalendarName.Prefix + event.Summary})
+		} else {
+			slog.Info("no TrainerRoad calendar event found")
+		}
+	}
+
+	if r.description != nil {
+		var b bytes.Buffer
+		if err := r.description.Execute(&b, data); err != nil {
+			slog.Error("unable to execute description template", "rule", r.Name, "error", err)
+		} else {
+			cs = append(cs, change{"description", b.String()})
+		}
+	}
+	if set.GearID != "" {
+		id := set.GearID
+		if alias, ok := rs.Gear[id]; ok {
+			id = alias
+		}
+		cs = append(cs, change{"gear_id", id})
+	}
+	if set.Type != "" {
+		cs = append(cs, change{"type", set.Type})
+	}
+	if set.SportType != "" {
+		cs = append(cs, change{"sport_type", set.SportType})
+	}
+	for _, b := range []struct {
+		field string
+		value *bool
+	}{
+		{"commute", set.Commute},
+		{"hide_from_home", set.HideFromHome},
+		{"private", set.Private},
+		{"trainer", set.Trainer},
+		{"with_pet", set.WithPet},
+		{"weather", set.Weather},
+	} {
+		if b.value != nil {
+			cs = append(cs, change{b.field, *b.value})
+		}
+	}
+
+	return cs
+}
+
+// get returns the current value of the field.
+func (res *Result) get(field string) any {
+	ua := &res.Update
+	switch field {
+	case "name":
+		return ua.Name
+	case "description":
+		return ua.Description
+	case "gear_id":
+		return ua.GearID
+	case "type":
+		return ua.Type
+	case "sport_type":
+		return ua.SportType
+	case "commute":
+		return boolValue(ua.Commute)
+	case "hide_from_home":
+		return boolValue(ua.HideFromHome)
+	case "private":
+		return boolValue(ua.Private)
+	case "trainer":
+		return boolValue(ua.Trainer)
+	case "with_pet":
+		return boolValue(ua.WithPet)
+	case "weather":
+		return res.Weather
+	}
+	return nil
+}
+
+// set sets the field to the value.
+func (res *Result) set(field string, value any) {
+	ua := &res.Update
+	switch field {
+	case "name":
+		ua.Name, _ = value.(string)
+	case "description":
+		ua.Description, _ = value.(string)
+	case "gear_id":
+		ua.GearID, _ = value.(string)
+	case "type":
+		ua.Type, _ = value.(string)
+	case "sport_type":
+		ua.SportType, _ = value.(string)
+	case "commute":
+		ua.Commute = boolPtr(value)
+	case "hide_from_home":
+		ua.HideFromHome = boolPtr(value)
+	case "private":
+		ua.Private = boolPtr(value)
+	case "trainer":
+		ua.Trainer = boolPtr(value)
+	case "with_pet":
+		ua.WithPet = boolPtr(value)
+	case "weather":
+		res.Weather, _ = value.(bool)
+	}
+}
+
+// boolValue returns the value of an update's boolean, or nil if it isn't set.
+func boolValue(b *bool) any {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
+
+// boolPtr returns the value as an update's boolean.
+func boolPtr(value any) *bool {
+	b, _ := value.(bool)
+	return strava.Bool(b)
+}
@@ -0,0 +1,583 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lildude/strautomagically/internal/calendarevent"
+	"github.com/lildude/strautomagically/internal/strava"
+)
+
+type mockCalendar struct {
+	summary string
+}
+
+func (m mockCalendar) GetCalendarEvent(_ context.Context, _ time.Time) (*calendarevent.Event, error) {
+	return &calendarevent.Event{Summary: m.summary}, nil
+}
+
+func TestLoad(t *testing.T) {
+	rs, err := Load("../../rules.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error loading default rules: %v", err)
+	}
+	if len(rs.Rules) == 0 {
+		t.Error("expected default rules, got none")
+	}
+
+	if _, err := Load("testdata/does_not_exist.yaml"); err == nil {
+		t.Error("expected error loading missing file, got nil")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  string
+	}{
+		{
+			"missing name",
+			"rules:\n  - match:\n      type: Ride\n",
+			"rule 1: missing name",
+		},
+		{
+			"duplicate name",
+			"rules:\n  - name: Ride\n  - name: Ride\n",
+			`rule "Ride": duplicate name`,
+		},
+		{
+			"unknown field",
+			"rules:\n  - name: Ride\n    match:\n      colour: red\n",
+			"field colour not found",
+		},
+		{
+			"unknown conflicts policy",
+			"conflicts: random\nrules: []\n",
+			`unknown conflicts policy "random"`,
+		},
+		{
+			"unknown event",
+			"rules:\n  - name: Ride\n    on: delete\n",
+			`rule "Ride": unknown event "delete"`,
+		},
+		{
+			"invalid name template",
+			"rules:\n  - name: Ride\n    set:\n      name: \"{{ .Name \"\n",
+			`rule "Ride": parsing name template`,
+		},
+		{
+			"invalid description template",
+			"rules:\n  - name: Ride\n    set:\n      description: \"{{ range .Laps }}\"\n",
+			`rule "Ride": parsing description template`,
+		},
+		{
+			"unknown detail to fetch",
+			"fetch: [laps, photos]\nrules: []\n",
+			`unknown detail to fetch "photos"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(tc.rules))
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected error containing %q, got %q", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	rs, err := Parse([]byte(`
+gear:
+  bike: b1234
+rules:
+  - name: No weather
+    match:
+      type: Handcycle
+    set:
+      weather: false
+  - name: Calendar
+    match:
+      type: Ride
+      external_id_prefix: trainerroad
+    set:
+      trainer: true
+      calendar_name:
+        prefix: "TR: "
+  - name: Morning ride
+    match:
+      type: Ride
+      start_hour:
+        max: 8
+      elapsed_time:
+        min: 600
+        max: 3600
+    set:
+      name: "Early {{ .Name }}"
+      gear_id: bike
+  - name: Any ride
+    match:
+      type: [Ride, VirtualRide]
+    set:
+      gear_id: b5678
+  - name: First line
+    match:
+      type: Rowing
+      description_contains: erg
+    set:
+      name: "{{ firstLine .Description }}"
+      description: "\n"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error parsing rules: %v", err)
+	}
+
+	morning := time.Date(2022, 7, 12, 7, 0, 0, 0, time.UTC)
+	evening := time.Date(2022, 7, 12, 19, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		activity strava.Activity
+		want     *Result
+	}{
+		{
+			"no matching rule",
+			strava.Activity{Type: "Run"},
+			&Result{Weather: true},
+		},
+		{
+			"disable weather",
+			strava.Activity{Type: "Handcycle"},
+			&Result{Rules: []string{"No weather"}},
+		},
+		{
+			"name from calendar",
+			strava.Activity{Type: "Ride", Name: "Morning Ride", ExternalID: "trainerroad-1234"},
+			&Result{Rules: []string{"Calendar", "Any ride"}, Weather: true, Update: strava.UpdatableActivity{Name: "TR: Capulin", Trainer: strava.Bool(true), GearID: "b5678"}},
+		},
+		{
+			"calendar skipped when already named",
+			strava.Activity{Type: "Ride", Name: "TR: Baxter", ExternalID: "trainerroad-1234"},
+			&Result{Rules: []string{"Calendar", "Any ride"}, Weather: true, Update: strava.UpdatableActivity{Trainer: strava.Bool(true), GearID: "b5678"}},
+		},
+		{
+			"first rule wins conflicts and gear name is resolved",
+			strava.Activity{Type: "Ride", Name: "Ride", StartDateLocal: morning, ElapsedTime: 1800},
+			&Result{Rules: []string{"Morning ride", "Any ride"}, Weather: true, Update: strava.UpdatableActivity{Name: "Early Ride", GearID: "b1234"}},
+		},
+		{
+			"out of range rule is skipped",
+			strava.Activity{Type: "Ride", Name: "Ride", StartDateLocal: evening, ElapsedTime: 1800},
+			&Result{Rules: []string{"Any ride"}, Weather: true, Update: strava.UpdatableActivity{GearID: "b5678"}},
+		},
+		{
+			"type from list",
+			strava.Activity{Type: "VirtualRide"},
+			&Result{Rules: []string{"Any ride"}, Weather: true, Update: strava.UpdatableActivity{GearID: "b5678"}},
+		},
+		{
+			"name from first line of description",
+			strava.Activity{Type: "Rowing", Description: "4x 1k\nfrom erg"},
+			&Result{Rules: []string{"First line"}, Weather: true, Update: strava.UpdatableActivity{Name: "4x 1k", Description: "\n"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := rs.Apply(context.Background(), &tc.activity, mockCalendar{summary: "Capulin"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyNilRuleSet(t *testing.T) {
+	var rs *RuleSet
+	got, _ := rs.Apply(context.Background(), &strava.Activity{Type: "Ride"}, nil)
+	if !reflect.DeepEqual(got, &Result{Weather: true}) {
+		t.Errorf("expected no changes, got %+v", got)
+	}
+}
+
+func TestApplyEvent(t *testing.T) {
+	rs, err := Parse([]byte(`
+rules:
+  - name: New walk
+    match:
+      type: Walk
+    set:
+      hide_from_home: true
+  - name: Renamed walk
+    on: update
+    when: updates.title contains "dog"
+    set:
+      with_pet: true
+  - name: Any walk
+    on: [create, update]
+    match:
+      type: Walk
+    set:
+      gear_id: shoes
+`))
+	if err != nil {
+		t.Fatalf("unexpected error parsing rules: %v", err)
+	}
+	if !rs.HandlesUpdates() {
+		t.Error("expected rules to handle updates")
+	}
+
+	a := &strava.Activity{Type: "Walk", Name: "Walk with the dog"}
+	tests := []struct {
+		name string
+		ev   Event
+		want *Result
+	}{
+		{
+			"create",
+			Event{AspectType: EventCreate},
+			&Result{Rules: []string{"New walk", "Any walk"}, Weather: true, Update: strava.UpdatableActivity{HideFromHome: strava.Bool(true), GearID: "shoes"}},
+		},
+		{
+			"update with title",
+			Event{AspectType: EventUpdate, Updates: strava.Updates{Title: "Walk with the dog"}},
+			&Result{Rules: []string{"Renamed walk", "Any walk"}, Weather: true, Update: strava.UpdatableActivity{WithPet: strava.Bool(true), GearID: "shoes"}},
+		},
+		{
+			"update without title",
+			Event{AspectType: EventUpdate, Updates: strava.Updates{Private: "true"}},
+			&Result{Rules: []string{"Any walk"}, Weather: true, Update: strava.UpdatableActivity{GearID: "shoes"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := rs.ApplyEvent(context.Background(), a, tc.ev, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+
+	rs, _ = Parse([]byte("rules:\n  - name: Walk\n"))
+	if rs.HandlesUpdates() {
+		t.Error("expected rules not to handle updates")
+	}
+}
+
+func TestApplyAthlete(t *testing.T) {
+	rs, err := Parse([]byte(`
+rules:
+  - name: Team
+    match:
+      athlete: [1, 2]
+    set:
+      private: true
+  - name: Athlete 3
+    when: athlete == 3
+    set:
+      commute: true
+`))
+	if err != nil {
+		t.Fatalf("unexpected error parsing rules: %v", err)
+	}
+
+	tests := []struct {
+		athlete int64
+		want    strava.UpdatableActivity
+	}{
+		{1, strava.UpdatableActivity{Private: strava.Bool(true)}},
+		{2, strava.UpdatableActivity{Private: strava.Bool(true)}},
+		{3, strava.UpdatableActivity{Commute: strava.Bool(true)}},
+		{4, strava.UpdatableActivity{}},
+	}
+
+	for _, tc := range tests {
+		a := &strava.Activity{Athlete: strava.Athlete{ID: tc.athlete}}
+		got, err := rs.Apply(context.Background(), a, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got.Update, tc.want) {
+			t.Errorf("athlete %d: expected %+v, got %+v", tc.athlete, tc.want, got.Update)
+		}
+	}
+}
+
+func TestApplyPipeline(t *testing.T) {
+	const rules = `
+rules:
+  - name: Walk
+    match:
+      type: Walk
+    set:
+      hide_from_home: true
+      gear_id: shoes
+  - name: Commute
+    when: commute
+    priority: 10
+    set:
+      name: Commute
+      gear_id: boots
+  - name: Stop
+    match:
+      type: Walk
+    stop: true
+  - name: After stop
+    set:
+      private: true
+`
+
+	tests := []struct {
+		name      string
+		conflicts string
+		want      *Result
+		wantErr   string
+	}{
+		{
+			"first wins",
+			"first-wins",
+			&Result{
+				Rules:   []string{"Commute", "Walk", "Stop"},
+				Weather: true,
+				Update:  strava.UpdatableActivity{Name: "Commute", GearID: "boots", HideFromHome: strava.Bool(true)},
+			},
+			"",
+		},
+		{
+			"last wins",
+			"last-wins",
+			&Result{
+				Rules:   []string{"Commute", "Walk", "Stop"},
+				Weather: true,
+				Update:  strava.UpdatableActivity{Name: "Commute", GearID: "shoes", HideFromHome: strava.Bool(true)},
+			},
+			"",
+		},
+		{
+			"error",
+			"error",
+			nil,
+			`rules "Commute" and "Walk" both set gear_id`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := Parse([]byte("conflicts: " + tc.conflicts + "\n" + rules))
+			if err != nil {
+				t.Fatalf("unexpected error parsing rules: %v", err)
+			}
+
+			got, err := rs.Apply(context.Background(), &strava.Activity{Type: "Walk", Commute: true}, nil)
+			if tc.wantErr != "" {
+				var ce *ConflictError
+				if !errors.As(err, &ce) || err.Error() != tc.wantErr {
+					t.Fatalf("expected conflict error %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	rs, err := Parse([]byte(`
+rules:
+  - name: Dog walk
+    match:
+      type: Walk
+    when: hour < 9 and (commute or elapsed_time >= 1200)
+    set:
+      name: Dog walk
+      with_pet: true
+    stop: true
+  - name: Walk
+    match:
+      type: Walk
+    set:
+      hide_from_home: true
+  - name: Ride
+    match:
+      type: Ride
+`))
+	if err != nil {
+		t.Fatalf("unexpected error parsing rules: %v", err)
+	}
+
+	a := &strava.Activity{Type: "Walk", ElapsedTime: 1800, StartDateLocal: time.Date(2022, 7, 12, 7, 0, 0, 0, time.UTC)}
+	got, err := rs.Explain(context.Background(), a, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []RuleTrace{
+		{
+			Rule:    "Dog walk",
+			Matched: true,
+			Applied: true,
+			Conditions: []Condition{
+				{Condition: `type in ["Walk"]`, Value: "Walk", Matched: true},
+				{Condition: "hour < 9", Value: float64(7), Matched: true},
+				{Condition: "commute", Value: false, Matched: false},
+				{Condition: "elapsed_time >= 1200", Value: float64(1800), Matched: true},
+			},
+			Changes: []string{`name: "Dog walk"`, `with_pet: true`},
+		},
+		{
+			Rule:       "Walk",
+			Matched:    true,
+			Conditions: []Condition{{Condition: `type in ["Walk"]`, Value: "Walk", Matched: true}},
+			Note:       `not applied: stopped by rule "Dog walk"`,
+		},
+		{
+			Rule:       "Ride",
+			Conditions: []Condition{{Condition: `type in ["Ride"]`, Value: "Walk", Matched: false}},
+		},
+	}
+	if !reflect.DeepEqual(got.Trace, want) {
+		t.Errorf("expected %+v, got %+v", want, got.Trace)
+	}
+	if !reflect.DeepEqual(got.Rules, []string{"Dog walk"}) {
+		t.Errorf("expected only Dog walk to be applied, got %v", got.Rules)
+	}
+}
+
+func TestNeeds(t *testing.T) {
+	tests := []struct {
+		name                  string
+		rules                 string
+		wantLaps, wantStreams bool
+	}{
+		{"nothing", "rules:\n  - name: Ride\n    when: type == \"Ride\"\n", false, false},
+		{"fetched for templates", "fetch: [laps, streams]\nrules: []\n", true, true},
+		{"used by when", "rules:\n  - name: Intervals\n    when: laps > 4\n  - name: Hard\n    when: max_heartrate > 170\n", true, true},
+		{"splits come with the activity", "rules:\n  - name: Long\n    when: splits > 20\n", false, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := Parse([]byte(tc.rules))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := rs.Needs(DetailLaps); got != tc.wantLaps {
+				t.Errorf("expected needs laps %v, got %v", tc.wantLaps, got)
+			}
+			if got := rs.Needs(DetailStreams); got != tc.wantStreams {
+				t.Errorf("expected needs streams %v, got %v", tc.wantStreams, got)
+			}
+		})
+	}
+
+	var rs *RuleSet
+	if rs.Needs(DetailLaps) {
+		t.Error("expected nil rule set to need nothing")
+	}
+}
+
+func TestDescriptionTemplate(t *testing.T) {
+	rs, err := Parse([]byte(`
+fetch: streams
+rules:
+  - name: Intervals
+    when: laps > 1
+    set:
+      description: |-
+        {{ len .Laps }} laps
+        {{ range .Laps }}{{ .Name }}: {{ printf "%.0f" .AverageWatts }}W
+        {{ end }}Max HR {{ .Streams.MaxHeartrate }}
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a := &strava.Activity{
+		Laps:    []strava.Lap{{Name: "Lap 1", AverageWatts: 182.6}, {Name: "Lap 2", AverageWatts: 301.2}},
+		Streams: &strava.Streams{Heartrate: []int{120, 176, 150}},
+	}
+	got, err := rs.Apply(context.Background(), a, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "2 laps\nLap 1: 183W\nLap 2: 301W\nMax HR 176"
+	if got.Update.Description != want {
+		t.Errorf("expected description %q, got %q", want, got.Update.Description)
+	}
+}
+
+func TestSportType(t *testing.T) {
+	rs, err := Parse([]byte(`
+rules:
+  - name: Gravel
+    match:
+      sport_type: GravelRide
+    set:
+      commute: false
+      trainer: false
+  - name: Indoor
+    match:
+      sport_type: Ride
+    when: not has_map
+    set:
+      sport_type: VirtualRide
+      trainer: true
+`))
+	if err != nil {
+		t.Fatalf("unexpected error parsing rules: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		activity strava.Activity
+		want     strava.UpdatableActivity
+	}{
+		{
+			"sport type takes precedence over type",
+			strava.Activity{Type: "Ride", SportType: "GravelRide", Map: strava.Map{SummaryPolyline: "abc"}},
+			strava.UpdatableActivity{Commute: strava.Bool(false), Trainer: strava.Bool(false)},
+		},
+		{
+			"type when there's no sport type",
+			strava.Activity{Type: "Ride"},
+			strava.UpdatableActivity{SportType: "VirtualRide", Trainer: strava.Bool(true)},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := rs.Apply(context.Background(), &tc.activity, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got.Update, tc.want) {
+				t.Errorf("expected %+v, got %+v", tc.want, got.Update)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Package secrets encrypts values before they're stored so they're safe in a cache we don't fully trust.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prefix starts every encrypted value so they can be told apart from plaintext values
+// stored before encryption was enabled. It's followed by the key ID and the ciphertext.
+const prefix = "enc:v1:"
+
+// ErrUnknownKey is returned when decrypting a value encrypted with a key that isn't in the keyring.
+var ErrUnknownKey = errors.New("value encrypted with an unknown key")
+
+// Keyring holds the AES-256-GCM keys used to encrypt and decrypt values. The first
+// key encrypts new values and every key can decrypt, so keys can be rotated by adding
+// a new key to the front and removing the old one once nothing uses it.
+type Keyring struct {
+	keys []key
+}
+
+type key struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewKeyring returns a keyring for the 32 byte keys, the first of which is used to encrypt.
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+
+	kr := &Keyring{}
+	for i, k := range keys {
+		if len(k) != 32 {
+			return nil, fmt.Errorf("key %d is %d bytes: must be 32", i+1, len(k))
+		}
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
+		}
+		// Identify keys by a hash so the ID doesn't reveal anything about the key
+		sum := sha256.Sum256(k)
+		kr.keys = append(kr.keys, key{id: hex.EncodeToString(sum[:4]), aead: aead})
+	}
+	return kr, nil
+}
+
+// ParseKeyring returns a keyring for the comma separated list of base64 encoded keys,
+// or nil if the list is empty.
+func ParseKeyring(s string) (*Keyring, error) {
+	if strings.TrimSpace(s) == "" {
+		// No keys means encryption is disabled
+		return nil, nil
+	}
+
+	var keys [][]byte
+	for i, enc := range strings.Split(s, ",") {
+		k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(enc))
+		if err != nil {
+			return nil, fmt.Errorf("decoding key %d: %w", i+1, err)
+		}
+		keys = append(keys, k)
+	}
+	return NewKeyring(keys...)
+}
+
+// FromEnv returns the keyring in TOKEN_ENCRYPTION_KEYS, or nil if it isn't set.
+func FromEnv() (*Keyring, error) {
+	kr, err := ParseKeyring(os.Getenv("TOKEN_ENCRYPTION_KEYS"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing TOKEN_ENCRYPTION_KEYS: %w", err)
+	}
+	return kr, nil
+}
+
+// Encrypted reports whether the value was encrypted by a Keyring.
+func Encrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt encrypts the plaintext with the first key. The additional data isn't encrypted
+// but must be the same to decrypt the value, which stops a value being copied to another
+// key in the cache, so pass the cache key.
+func (kr *Keyring) Encrypt(plaintext, additionalData []byte) (string, error) {
+	k := kr.keys[0]
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generating nonce: %w", err)
+	}
+	sealed := k.aead.Seal(nonce, nonce, plaintext, additionalData)
+	return prefix + k.id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value returned by Encrypt with any key in the keyring.
+func (kr *Keyring) Decrypt(value string, additionalData []byte) ([]byte, error) {
+	k, sealed, err := kr.parse(value)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := k.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("encrypted value is too short")
+	}
+	plaintext, err := k.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Current reports whether the value is encrypted with the first key, so doesn't need re-encrypting.
+func (kr *Keyring) Current(value string) bool {
+	id, _, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	return Encrypted(value) && ok && id == kr.keys[0].id
+}
+
+// parse returns the key and sealed ciphertext from the encrypted value.
+func (kr *Keyring) parse(value string) (key, []byte, error) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return key{}, nil, errors.New("value isn't encrypted")
+	}
+	id, enc, ok := strings.Cut(rest, ":")
+	if !ok {
+		return key{}, nil, errors.New("malformed encrypted value")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return key{}, nil, fmt.Errorf("decoding encrypted value: %w", err)
+	}
+	for _, k := range kr.keys {
+		if k.id == id {
+			return k, sealed, nil
+		}
+	}
+	return key{}, nil, fmt.Errorf("%w %s", ErrUnknownKey, id)
+}
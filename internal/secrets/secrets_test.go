@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestKeyring(t *testing.T) {
+	oldRing, err := NewKeyring(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := oldRing.Encrypt([]byte("secret"), []byte("key"))
+	if err != nil {
+		t.Fatalf("unexpected error encrypting: %v", err)
+	}
+	if !Encrypted(enc) || strings.Contains(enc, "secret") {
+		t.Errorf("expected value to be encrypted, got %s", enc)
+	}
+	if again, _ := oldRing.Encrypt([]byte("secret"), []byte("key")); again == enc {
+		t.Error("expected encrypting the same value twice to give different ciphertext")
+	}
+
+	// Rotating adds the new key to the front and keeps the old one to decrypt
+	ring, err := NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newEnc, _ := ring.Encrypt([]byte("secret"), []byte("key"))
+
+	tests := []struct {
+		name        string
+		ring        *Keyring
+		value       string
+		data        string
+		wantCurrent bool
+		wantErr     bool
+	}{
+		{"current key", ring, newEnc, "key", true, false},
+		{"old key", ring, enc, "key", false, false},
+		{"removed key", oldRing, newEnc, "key", false, true},
+		{"different additional data", ring, newEnc, "other", true, true},
+		{"tampered", ring, newEnc[:len(newEnc)-4] + "AAA=", "key", true, true},
+		{"not encrypted", ring, `{"access_token":"secret"}`, "key", false, true},
+		{"malformed", ring, prefix + "nokeyid", "key", false, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.ring.Decrypt(tc.value, []byte(tc.data))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if !tc.wantErr && string(got) != "secret" {
+				t.Errorf("expected secret, got %s", got)
+			}
+			if current := tc.ring.Current(tc.value); current != tc.wantCurrent {
+				t.Errorf("expected current %v, got %v", tc.wantCurrent, current)
+			}
+		})
+	}
+
+	if _, err := oldRing.Decrypt(newEnc, []byte("key")); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected unknown key error, got %v", err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	b64 := func(k []byte) string { return base64.StdEncoding.EncodeToString(k) }
+
+	tests := []struct {
+		name     string
+		keys     string
+		wantNil  bool
+		wantKeys int
+		wantErr  bool
+	}{
+		{"empty", "", true, 0, false},
+		{"one key", b64(newKey), false, 1, false},
+		{"rotated keys", b64(newKey) + ", " + b64(oldKey), false, 2, false},
+		{"not base64", "not base64!", false, 0, true},
+		{"too short", b64([]byte("short")), false, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			kr, err := ParseKeyring(tc.keys)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+			if (kr == nil) != tc.wantNil {
+				t.Fatalf("expected nil keyring %v, got %v", tc.wantNil, kr)
+			}
+			if kr != nil && len(kr.keys) != tc.wantKeys {
+				t.Errorf("expected %d keys, got %d", tc.wantKeys, len(kr.keys))
+			}
+		})
+	}
+}
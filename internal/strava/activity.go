@@ -0,0 +1,184 @@
+package strava
+
+import "time"
+
+// Activity is Strava's DetailedActivity, as returned by GetActivity.
+type Activity struct {
+	AchievementCount int     `json:"achievement_count"`
+	Athlete          Athlete `json:"athlete"`
+	AthleteCount     int     `json:"athlete_count"`
+	AverageCadence   float64 `json:"average_cadence"`
+	AverageHeartrate float64 `json:"average_heartrate"`
+	// AverageSpeed and MaxSpeed are in meters per second.
+	AverageSpeed float64 `json:"average_speed"`
+	// AverageTemp is in degrees Celsius.
+	AverageTemp  float64 `json:"average_temp"`
+	AverageWatts float64 `json:"average_watts"`
+	// BestEfforts holds the fastest times over standard distances, for runs only.
+	BestEfforts  []SegmentEffort `json:"best_efforts,omitempty"`
+	Calories     float64         `json:"calories"`
+	CommentCount int             `json:"comment_count"`
+	Commute      bool            `json:"commute"`
+	Description  string          `json:"description"`
+	DeviceName   string          `json:"device_name"`
+	// DeviceWatts reports whether the watts are from a power meter rather than estimated.
+	DeviceWatts  bool      `json:"device_watts"`
+	Distance     float64   `json:"distance"`
+	ElapsedTime  int64     `json:"elapsed_time"`
+	ElevHigh     float64   `json:"elev_high"`
+	ElevLow      float64   `json:"elev_low"`
+	EmbedToken   string    `json:"embed_token"`
+	EndLatlng    []float64 `json:"end_latlng"`
+	ExternalID   string    `json:"external_id"`
+	Flagged      bool      `json:"flagged"`
+	Gear         *Gear     `json:"gear,omitempty"`
+	GearID       string    `json:"gear_id"`
+	HasHeartrate bool      `json:"has_heartrate"`
+	HasKudoed    bool      `json:"has_kudoed"`
+	HideFromHome bool      `json:"hide_from_home"`
+	ID           int64     `json:"id"`
+	Kilojoules   float64   `json:"kilojoules"`
+	KudosCount   int       `json:"kudos_count"`
+	// Laps and SplitsMetric are only included in the detailed activity returned by GetActivity.
+	Laps         []Lap   `json:"laps,omitempty"`
+	Manual       bool    `json:"manual"`
+	Map          Map     `json:"map"`
+	MaxHeartrate float64 `json:"max_heartrate"`
+	MaxSpeed     float64 `json:"max_speed"`
+	MaxWatts     int     `json:"max_watts"`
+	MovingTime   int64   `json:"moving_time"`
+	Name         string  `json:"name"`
+	// PhotoCount is the number of Instagram photos. TotalPhotoCount includes photos uploaded to Strava.
+	PhotoCount     int             `json:"photo_count"`
+	Photos         Photos          `json:"photos"`
+	PRCount        int             `json:"pr_count"`
+	Private        bool            `json:"private"`
+	SegmentEfforts []SegmentEffort `json:"segment_efforts,omitempty"`
+	SplitsMetric   []Split         `json:"splits_metric,omitempty"`
+	SplitsStandard []Split         `json:"splits_standard,omitempty"`
+	// SportType replaces Type, which is deprecated, but isn't set on older activities. Use Sport to get whichever is set.
+	SportType      string    `json:"sport_type"`
+	StartDate      time.Time `json:"start_date"`
+	StartDateLocal time.Time `json:"start_date_local"`
+	StartLatlng    []float64 `json:"start_latlng"`
+	// Streams isn't part of Strava's activity. It's added by GetDetails if the rules need it.
+	Streams     *Streams `json:"streams,omitempty"`
+	SufferScore float64  `json:"suffer_score"`
+	// Timezone is the IANA timezone of the start, prefixed with its UTC offset, eg "(GMT+00:00) Europe/London".
+	Timezone             string  `json:"timezone"`
+	TotalElevationGain   float64 `json:"total_elevation_gain"`
+	TotalPhotoCount      int     `json:"total_photo_count"`
+	Trainer              bool    `json:"trainer"`
+	Type                 string  `json:"type"`
+	UploadID             int64   `json:"upload_id"`
+	UTCOffset            float64 `json:"utc_offset"`
+	Visibility           string  `json:"visibility"`
+	WeightedAverageWatts int     `json:"weighted_average_watts"`
+	WorkoutType          int     `json:"workout_type"`
+}
+
+// Sport returns the activity's sport type, eg MountainBikeRide, or the less specific
+// deprecated type, eg Ride, if it doesn't have one.
+func (a *Activity) Sport() string {
+	if a.SportType != "" {
+		return a.SportType
+	}
+	return a.Type
+}
+
+// Athlete identifies the athlete an activity belongs to.
+type Athlete struct {
+	ID int64 `json:"id"`
+}
+
+// Map holds the route of an activity as encoded polylines. Polyline is only included
+// in the detailed activity.
+type Map struct {
+	ID              string `json:"id"`
+	Polyline        string `json:"polyline"`
+	SummaryPolyline string `json:"summary_polyline"`
+}
+
+// Photos summarises the photos attached to an activity.
+type Photos struct {
+	Count   int    `json:"count"`
+	Primary *Photo `json:"primary,omitempty"`
+}
+
+// Photo is an activity's primary photo. URLs maps sizes, eg "100" or "600", to the photo's URL.
+type Photo struct {
+	ID       int64             `json:"id"`
+	UniqueID string            `json:"unique_id"`
+	Source   int               `json:"source"`
+	URLs     map[string]string `json:"urls"`
+}
+
+// Gear is the bike or shoes used for an activity.
+type Gear struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Primary bool   `json:"primary"`
+	// Distance is the total distance recorded with the gear in meters.
+	Distance float64 `json:"distance"`
+}
+
+// SegmentEffort is an athlete's attempt at a segment, or a best effort over a standard distance.
+type SegmentEffort struct {
+	ID               int64     `json:"id"`
+	Name             string    `json:"name"`
+	StartDate        time.Time `json:"start_date"`
+	StartDateLocal   time.Time `json:"start_date_local"`
+	ElapsedTime      int64     `json:"elapsed_time"`
+	MovingTime       int64     `json:"moving_time"`
+	Distance         float64   `json:"distance"`
+	StartIndex       int       `json:"start_index"`
+	EndIndex         int       `json:"end_index"`
+	AverageCadence   float64   `json:"average_cadence"`
+	AverageWatts     float64   `json:"average_watts"`
+	DeviceWatts      bool      `json:"device_watts"`
+	AverageHeartrate float64   `json:"average_heartrate"`
+	MaxHeartrate     float64   `json:"max_heartrate"`
+	// PRRank and KOMRank are the effort's rank in the athlete's and everyone's top efforts, if it's in them.
+	PRRank  *int    `json:"pr_rank"`
+	KOMRank *int    `json:"kom_rank"`
+	Hidden  bool    `json:"hidden"`
+	Segment Segment `json:"segment"`
+}
+
+// Segment is a section of road or trail that athletes compete on.
+type Segment struct {
+	ID            int64   `json:"id"`
+	Name          string  `json:"name"`
+	ActivityType  string  `json:"activity_type"`
+	Distance      float64 `json:"distance"`
+	AverageGrade  float64 `json:"average_grade"`
+	MaximumGrade  float64 `json:"maximum_grade"`
+	ElevationHigh float64 `json:"elevation_high"`
+	ElevationLow  float64 `json:"elevation_low"`
+	ClimbCategory int     `json:"climb_category"`
+	City          string  `json:"city"`
+	State         string  `json:"state"`
+	Country       string  `json:"country"`
+	Private       bool    `json:"private"`
+}
+
+// UpdatableActivity holds the changes to make to an activity. Only the fields that are set
+// are changed, so the booleans are pointers to tell setting them to false from leaving them
+// alone. Strava ignores Type if SportType is set.
+type UpdatableActivity struct {
+	Commute      *bool  `json:"commute,omitempty"`
+	Description  string `json:"description,omitempty"`
+	GearID       string `json:"gear_id,omitempty"`
+	HideFromHome *bool  `json:"hide_from_home,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Private      *bool  `json:"private,omitempty"`
+	SportType    string `json:"sport_type,omitempty"`
+	Trainer      *bool  `json:"trainer,omitempty"`
+	Type         string `json:"type,omitempty"`
+	WithPet      *bool  `json:"with_pet,omitempty"`
+}
+
+// Bool returns a pointer to b for setting an UpdatableActivity's booleans.
+func Bool(b bool) *bool {
+	return &b
+}
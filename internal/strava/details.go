@@ -0,0 +1,226 @@
+package strava
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lildude/strautomagically/internal/client"
+)
+
+// Lap is a lap of an activity, either split manually or automatically by the device.
+type Lap struct {
+	ID                 int64     `json:"id"`
+	Name               string    `json:"name"`
+	LapIndex           int       `json:"lap_index"`
+	Split              int       `json:"split"`
+	StartDate          time.Time `json:"start_date"`
+	StartDateLocal     time.Time `json:"start_date_local"`
+	ElapsedTime        int64     `json:"elapsed_time"`
+	MovingTime         int64     `json:"moving_time"`
+	Distance           float64   `json:"distance"`
+	TotalElevationGain float64   `json:"total_elevation_gain"`
+	AverageSpeed       float64   `json:"average_speed"`
+	MaxSpeed           float64   `json:"max_speed"`
+	AverageCadence     float64   `json:"average_cadence"`
+	AverageWatts       float64   `json:"average_watts"`
+	DeviceWatts        bool      `json:"device_watts"`
+	AverageHeartrate   float64   `json:"average_heartrate"`
+	MaxHeartrate       float64   `json:"max_heartrate"`
+	PaceZone           int       `json:"pace_zone"`
+	StartIndex         int       `json:"start_index"`
+	EndIndex           int       `json:"end_index"`
+}
+
+// Split is a kilometer of an activity, from the activity's splits_metric.
+type Split struct {
+	Split                     int     `json:"split"`
+	Distance                  float64 `json:"distance"`
+	ElapsedTime               int64   `json:"elapsed_time"`
+	MovingTime                int64   `json:"moving_time"`
+	ElevationDifference       float64 `json:"elevation_difference"`
+	AverageSpeed              float64 `json:"average_speed"`
+	AverageGradeAdjustedSpeed float64 `json:"average_grade_adjusted_speed"`
+	AverageHeartrate          float64 `json:"average_heartrate"`
+	PaceZone                  int     `json:"pace_zone"`
+}
+
+// Streams holds the samples recorded during an activity, one per point in Time. Streams
+// the device didn't record are empty.
+type Streams struct {
+	// Time is the number of seconds since the start of the activity.
+	Time []int64 `json:"time,omitempty"`
+	// Distance is in meters.
+	Distance []float64    `json:"distance,omitempty"`
+	LatLng   [][2]float64 `json:"latlng,omitempty"`
+	// Altitude is in meters.
+	Altitude  []float64 `json:"altitude,omitempty"`
+	Heartrate []int     `json:"heartrate,omitempty"`
+	Watts     []int     `json:"watts,omitempty"`
+	Cadence   []int     `json:"cadence,omitempty"`
+	// Temp is in degrees Celsius.
+	Temp []int `json:"temp,omitempty"`
+}
+
+// streamKeys are the streams requested by GetStreams.
+var streamKeys = []string{"time", "distance", "latlng", "altitude", "heartrate", "watts", "cadence", "temp"}
+
+// MaxHeartrate returns the highest heart rate recorded, or 0 if it wasn't.
+func (s *Streams) MaxHeartrate() int {
+	if s == nil {
+		return 0
+	}
+	return maxOf(s.Heartrate)
+}
+
+// AverageHeartrate returns the average heart rate recorded, or 0 if it wasn't.
+func (s *Streams) AverageHeartrate() float64 {
+	if s == nil {
+		return 0
+	}
+	return average(s.Heartrate)
+}
+
+// MaxWatts returns the highest power recorded, or 0 if it wasn't.
+func (s *Streams) MaxWatts() int {
+	if s == nil {
+		return 0
+	}
+	return maxOf(s.Watts)
+}
+
+// AverageWatts returns the average power recorded, or 0 if it wasn't.
+func (s *Streams) AverageWatts() float64 {
+	if s == nil {
+		return 0
+	}
+	return average(s.Watts)
+}
+
+// MaxCadence returns the highest cadence recorded, or 0 if it wasn't.
+func (s *Streams) MaxCadence() int {
+	if s == nil {
+		return 0
+	}
+	return maxOf(s.Cadence)
+}
+
+// AverageTemp returns the average temperature recorded, or 0 if it wasn't.
+func (s *Streams) AverageTemp() float64 {
+	if s == nil {
+		return 0
+	}
+	return average(s.Temp)
+}
+
+func maxOf[T cmp.Ordered](samples []T) T {
+	var m T
+	for _, v := range samples {
+		m = max(m, v)
+	}
+	return m
+}
+
+func average(samples []int) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, v := range samples {
+		sum += v
+	}
+	return float64(sum) / float64(len(samples))
+}
+
+// GetLaps returns the activity's laps.
+func GetLaps(ctx context.Context, c *client.Client, id int64) ([]Lap, error) {
+	req, err := c.NewRequest(ctx, http.MethodGet, fmt.Sprintf("/api/v3/activities/%d/laps", id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating get laps request: %w", err)
+	}
+
+	var laps []Lap
+	resp, err := c.Do(req, &laps)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting laps for activity %d: %w", id, err)
+	}
+	return laps, nil
+}
+
+// GetStreams returns the activity's time, distance, latlng, altitude, heartrate, watts,
+// cadence and temp streams at full resolution.
+func GetStreams(ctx context.Context, c *client.Client, id int64) (*Streams, error) {
+	q := url.Values{"keys": {strings.Join(streamKeys, ",")}, "key_by_type": {"true"}}
+	req, err := c.NewRequest(ctx, http.MethodGet, fmt.Sprintf("/api/v3/activities/%d/streams?%s", id, q.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating get streams request: %w", err)
+	}
+
+	// Streams are keyed by type with the samples in data alongside details of how they were sampled
+	var raw map[string]struct {
+		Data json.RawMessage `json:"data"`
+	}
+	resp, err := c.Do(req, &raw)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting streams for activity %d: %w", id, err)
+	}
+
+	data := make(map[string]json.RawMessage, len(raw))
+	for k, v := range raw {
+		data[k] = v.Data
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding streams for activity %d: %w", id, err)
+	}
+	var s Streams
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("decoding streams for activity %d: %w", id, err)
+	}
+	return &s, nil
+}
+
+// GetDetails adds the laps, if the activity doesn't already have them, and the streams to
+// the activity. Each costs a request so only ask for what's needed. Activities without
+// laps or streams, like manual activities, get empty ones as Strava responds not found.
+func GetDetails(ctx context.Context, c *client.Client, a *Activity, laps, streams bool) error {
+	if laps && a.Laps == nil {
+		l, err := GetLaps(ctx, c, a.ID)
+		if err != nil && !notFound(err) {
+			return err
+		}
+		a.Laps = l
+		if a.Laps == nil {
+			a.Laps = []Lap{}
+		}
+	}
+	if streams && a.Streams == nil {
+		s, err := GetStreams(ctx, c, a.ID)
+		if err != nil && !notFound(err) {
+			return err
+		}
+		a.Streams = s
+		if a.Streams == nil {
+			a.Streams = &Streams{}
+		}
+	}
+	return nil
+}
+
+// notFound reports whether the request failed because the API responded not found.
+func notFound(err error) bool {
+	var apiErr *client.APIError
+	return errors.As(err, &apiErr) && apiErr.NotFound()
+}
@@ -0,0 +1,181 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGetLaps(t *testing.T) {
+	rc, mux, teardown := setup()
+	defer teardown()
+
+	resp, _ := os.ReadFile("testdata/laps.json")
+	mux.HandleFunc("/api/v3/activities/1234/laps", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, string(resp))
+	})
+
+	var want []Lap
+	json.Unmarshal(resp, &want)
+
+	got, err := GetLaps(context.Background(), rc, 1234)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if len(got) != 2 || got[1].AverageWatts != 301.2 {
+		t.Errorf("expected 2 laps with the second averaging 301.2W, got %+v", got)
+	}
+}
+
+func TestGetStreams(t *testing.T) {
+	rc, mux, teardown := setup()
+	defer teardown()
+
+	resp, _ := os.ReadFile("testdata/streams.json")
+	mux.HandleFunc("/api/v3/activities/1234/streams", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("keys"); got != "time,distance,latlng,altitude,heartrate,watts,cadence,temp" {
+			t.Errorf("unexpected keys %q", got)
+		}
+		if got := r.URL.Query().Get("key_by_type"); got != "true" {
+			t.Errorf("expected key_by_type=true, got %q", got)
+		}
+		fmt.Fprintln(w, string(resp))
+	})
+
+	want := &Streams{
+		Time:      []int64{0, 1, 2, 3},
+		Distance:  []float64{0, 4.2, 8.5, 12.9},
+		LatLng:    [][2]float64{{51.5, -0.12}, {51.5001, -0.1201}, {51.5002, -0.1202}, {51.5003, -0.1203}},
+		Altitude:  []float64{10.2, 10.4, 10.4, 10.6},
+		Heartrate: []int{120, 131, 142, 139},
+		Watts:     []int{150, 210, 305, 280},
+		Cadence:   []int{20, 24, 30, 28},
+	}
+
+	got, err := GetStreams(context.Background(), rc, 1234)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestGetStreamsError(t *testing.T) {
+	rc, mux, teardown := setup()
+	defer teardown()
+
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	mux.HandleFunc("/api/v3/activities/1234/streams", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	_, err := GetStreams(context.Background(), rc, 1234)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestGetDetails(t *testing.T) {
+	laps, _ := os.ReadFile("testdata/laps.json")
+	streams, _ := os.ReadFile("testdata/streams.json")
+
+	tests := []struct {
+		name          string
+		activity      *Activity
+		laps, streams bool
+		wantRequests  []string
+	}{
+		{"nothing", &Activity{ID: 1234}, false, false, nil},
+		{"laps", &Activity{ID: 1234}, true, false, []string{"laps"}},
+		{"laps already included", &Activity{ID: 1234, Laps: []Lap{{ID: 1}}}, true, false, nil},
+		{"streams", &Activity{ID: 1234}, false, true, []string{"streams"}},
+		{"both", &Activity{ID: 1234}, true, true, []string{"laps", "streams"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rc, mux, teardown := setup()
+			defer teardown()
+
+			var requests []string
+			mux.HandleFunc("/api/v3/activities/1234/laps", func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, "laps")
+				w.Write(laps)
+			})
+			mux.HandleFunc("/api/v3/activities/1234/streams", func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, "streams")
+				w.Write(streams)
+			})
+
+			if err := GetDetails(context.Background(), rc, tc.activity, tc.laps, tc.streams); err != nil {
+				t.Fatalf("expected nil error, got %q", err)
+			}
+			if !reflect.DeepEqual(requests, tc.wantRequests) {
+				t.Errorf("expected requests %v, got %v", tc.wantRequests, requests)
+			}
+			if tc.streams && tc.activity.Streams.MaxHeartrate() != 142 {
+				t.Errorf("expected max heart rate 142, got %d", tc.activity.Streams.MaxHeartrate())
+			}
+		})
+	}
+}
+
+func TestStreamsSummaries(t *testing.T) {
+	s := &Streams{Heartrate: []int{120, 140, 160}, Watts: []int{100, 300}, Cadence: []int{80, 95, 90}}
+	if got := s.MaxHeartrate(); got != 160 {
+		t.Errorf("expected max heart rate 160, got %d", got)
+	}
+	if got := s.AverageHeartrate(); got != 140 {
+		t.Errorf("expected average heart rate 140, got %v", got)
+	}
+	if got := s.MaxWatts(); got != 300 {
+		t.Errorf("expected max watts 300, got %d", got)
+	}
+	if got := s.AverageWatts(); got != 200 {
+		t.Errorf("expected average watts 200, got %v", got)
+	}
+	if got := s.MaxCadence(); got != 95 {
+		t.Errorf("expected max cadence 95, got %d", got)
+	}
+	if got := s.AverageTemp(); got != 0 {
+		t.Errorf("expected no temperature, got %v", got)
+	}
+
+	// Activities without streams have nothing to summarise
+	var none *Streams
+	if none.MaxHeartrate() != 0 || none.AverageWatts() != 0 {
+		t.Error("expected zero summaries for nil streams")
+	}
+}
+
+func TestGetDetailsNotFound(t *testing.T) {
+	rc, mux, teardown := setup()
+	defer teardown()
+
+	// Manual activities don't have any streams
+	mux.HandleFunc("/api/v3/activities/1234/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"Record Not Found"}`, http.StatusNotFound)
+	})
+
+	a := &Activity{ID: 1234, Manual: true}
+	if err := GetDetails(context.Background(), rc, a, true, true); err != nil {
+		t.Fatalf("expected nil error, got %q", err)
+	}
+	if a.Laps == nil || len(a.Laps) != 0 {
+		t.Errorf("expected empty laps, got %v", a.Laps)
+	}
+	if a.Streams == nil || a.Streams.MaxHeartrate() != 0 {
+		t.Errorf("expected empty streams, got %+v", a.Streams)
+	}
+}
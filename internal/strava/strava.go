@@ -5,8 +5,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
-	"time"
 
 	"github.com/lildude/strautomagically/internal/client"
 	"golang.org/x/oauth2"
@@ -26,37 +26,23 @@ var (
 	}
 )
 
-// Activity struct holds only the data we want from the Strava API for an activity.
-type Activity struct {
-	Commute        bool      `json:"commute"`
-	Description    string    `json:"description"`
-	Distance       float64   `json:"distance"`
-	ElapsedTime    int64     `json:"elapsed_time"`
-	EndLatlng      []float64 `json:"end_latlng"`
-	ExternalID     string    `json:"external_id"`
-	GearID         string    `json:"gear_id"`
-	HideFromHome   bool      `json:"hide_from_home"`
-	ID             int64     `json:"id"`
-	Name           string    `json:"name"`
-	Private        bool      `json:"private"`
-	StartDate      time.Time `json:"start_date"`
-	StartDateLocal time.Time `json:"start_date_local"`
-	StartLatlng    []float64 `json:"start_latlng"`
-	Trainer        bool      `json:"trainer"`
-	Type           string    `json:"type"`
-	WorkoutType    int       `json:"workout_type"`
+// rateLimits tracks Strava's rate limits, which apply to the application rather than an
+// athlete, so every client shares it.
+var rateLimits = &client.RateLimits{}
+
+// RateLimit returns Strava's rate limit usage reported by the most recent response.
+func RateLimit() client.Rate {
+	return rateLimits.Rate()
 }
 
-type UpdatableActivity struct {
-	Commute      bool   `json:"commute,omitempty"`
-	Description  string `json:"description,omitempty"`
-	GearID       string `json:"gear_id,omitempty"`
-	HideFromHome bool   `json:"hide_from_home,omitempty"`
-	Name         string `json:"name,omitempty"`
-	Private      bool   `json:"private,omitempty"`
-	Trainer      bool   `json:"trainer,omitempty"`
-	Type         string `json:"type,omitempty"`
-	WithPet      bool   `json:"with_pet,omitempty"`
+// newClient returns a Strava API client that sends requests with the HTTP client, retrying
+// transient failures.
+func newClient(hc *http.Client) *client.Client {
+	surl, _ := url.Parse(BaseURL)
+	c := client.NewClient(surl, hc)
+	c.RateLimits = rateLimits
+	c.Retry = &client.RetryPolicy{}
+	return c
 }
 
 type WebhookPayload struct {
@@ -66,16 +52,34 @@ type WebhookPayload struct {
 	ObjectType     string  `json:"object_type"`
 	OwnerID        int64   `json:"owner_id"`
 	SubscriptionID int64   `json:"subscription_id"`
-	Updates        updates `json:"updates"`
+	Updates        Updates `json:"updates"`
 }
 
-type updates struct {
+// Updates holds the fields changed by an update event. Private is "true" or "false".
+type Updates struct {
 	Authorized string `json:"authorized,omitempty"`
 	Private    string `json:"private,omitempty"`
 	Title      string `json:"title,omitempty"`
 	Type       string `json:"type,omitempty"`
 }
 
+// DeauthorizedKey returns the cache key recording that the athlete revoked our access.
+// Events for the athlete are ignored until they authorize us again.
+func DeauthorizedKey(athleteID int64) string {
+	return fmt.Sprintf("strava_deauthorized:%d", athleteID)
+}
+
+// AthleteID returns the ID of the athlete the token was issued to, from the
+// athlete details Strava includes in the token response.
+func AthleteID(token *oauth2.Token) (int64, bool) {
+	athlete, ok := token.Extra("athlete").(map[string]any)
+	if !ok {
+		return 0, false
+	}
+	id, ok := athlete["id"].(float64)
+	return int64(id), ok
+}
+
 func GetActivity(ctx context.Context, c *client.Client, id int64) (*Activity, error) {
 	var a Activity
 	req, err := c.NewRequest(ctx, http.MethodGet, fmt.Sprintf("/api/v3/activities/%d", id), nil)
@@ -13,6 +13,7 @@ import (
 	"testing"
 
 	"github.com/lildude/strautomagically/internal/client"
+	"golang.org/x/oauth2"
 )
 
 func TestGetActivity(t *testing.T) {
@@ -36,6 +37,64 @@ func TestGetActivity(t *testing.T) {
 	}
 }
 
+func TestGetActivityDetailed(t *testing.T) {
+	rc, mux, teardown := setup()
+	defer teardown()
+
+	resp, _ := os.ReadFile("testdata/activity.json")
+	mux.HandleFunc("/api/v3/activities/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, string(resp))
+	})
+
+	a, err := GetActivity(context.Background(), rc, 12345678987654321)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q", err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"sport type", a.SportType, "MountainBikeRide"},
+		{"device name", a.DeviceName, "Garmin Edge 1030"},
+		{"average watts", a.AverageWatts, 185.5},
+		{"average heart rate", a.AverageHeartrate, 140.3},
+		{"kudos", a.KudosCount, 19},
+		{"timezone", a.Timezone, "(GMT-08:00) America/Los_Angeles"},
+		{"summary polyline", a.Map.SummaryPolyline != "", true},
+		{"photos", a.Photos.Count, 2},
+		{"gear", a.Gear.Name, "Tarmac"},
+		{"laps", len(a.Laps), 1},
+		{"splits", len(a.SplitsMetric), 1},
+		{"segment", a.SegmentEfforts[0].Segment.Name, "Dash for the Ferry"},
+		{"pr rank", *a.SegmentEfforts[0].PRRank, 2},
+		{"no kom rank", a.SegmentEfforts[0].KOMRank == nil, true},
+	}
+	for _, tc := range tests {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, tc.got)
+		}
+	}
+}
+
+func TestSport(t *testing.T) {
+	if got := (&Activity{Type: "Ride", SportType: "GravelRide"}).Sport(); got != "GravelRide" {
+		t.Errorf("expected sport type to take precedence, got %q", got)
+	}
+	if got := (&Activity{Type: "Ride"}).Sport(); got != "Ride" {
+		t.Errorf("expected type when there's no sport type, got %q", got)
+	}
+}
+
+func TestUpdatableActivityJSON(t *testing.T) {
+	ua := &UpdatableActivity{Commute: Bool(false), Trainer: Bool(true), SportType: "VirtualRide"}
+	got, _ := json.Marshal(ua)
+	want := `{"commute":false,"sport_type":"VirtualRide","trainer":true}`
+	if string(got) != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
 func TestGetActivityError(t *testing.T) {
 	rc, mux, teardown := setup()
 	defer teardown()
@@ -67,9 +126,9 @@ func TestUpdateActivity(t *testing.T) {
 
 	update := &UpdatableActivity{
 		Name:         "Test Activity - Updated",
-		Commute:      true,
-		Trainer:      true,
-		HideFromHome: true,
+		Commute:      Bool(true),
+		Trainer:      Bool(true),
+		HideFromHome: Bool(true),
 		Description:  "Test activity description - Updated",
 		Type:         "Run",
 		GearID:       "b1234",
@@ -113,3 +172,13 @@ func setup() (rc *client.Client, mux *http.ServeMux, teardown func()) {
 
 	return c, mux, server.Close
 }
+
+func TestAthleteID(t *testing.T) {
+	token := (&oauth2.Token{}).WithExtra(map[string]any{"athlete": map[string]any{"id": float64(1234)}})
+	if id, ok := AthleteID(token); !ok || id != 1234 {
+		t.Errorf("expected 1234, got %d, %v", id, ok)
+	}
+	if _, ok := AthleteID(&oauth2.Token{}); ok {
+		t.Error("expected no athlete ID")
+	}
+}
@@ -0,0 +1,223 @@
+package strava
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/client"
+)
+
+// subscriptionsPath is the path of the push subscriptions API used to manage the webhook subscription.
+const subscriptionsPath = "/api/v3/push_subscriptions"
+
+// subscriptionKey is the cache key holding the webhook subscription so events can be checked against it.
+const subscriptionKey = "strava_subscription"
+
+// verifyTokenTTL is how long a verify token is valid for. Strava sends it to the callback
+// while creating the subscription so it only needs to last for the request.
+const verifyTokenTTL = 5 * time.Minute
+
+// verifyTokenKey returns the cache key recording that we generated the verify token.
+func verifyTokenKey(token string) string {
+	return "strava_verify_token:" + token
+}
+
+// NewVerifyToken generates a random verify token for a new subscription and stores it
+// in the cache so the callback can check Strava sends it back.
+func NewVerifyToken(ctx context.Context, c cache.Cache) (string, error) {
+	token := rand.Text()
+	if err := c.SetEX(ctx, verifyTokenKey(token), time.Now().Unix(), verifyTokenTTL); err != nil {
+		return "", fmt.Errorf("storing verify token: %w", err)
+	}
+	return token, nil
+}
+
+// ValidVerifyToken reports whether the verify token was generated by NewVerifyToken and
+// hasn't expired or been discarded.
+func ValidVerifyToken(ctx context.Context, c cache.Cache, token string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	return c.Exists(ctx, verifyTokenKey(token))
+}
+
+// DiscardVerifyToken deletes the verify token so it can't be used again.
+func DiscardVerifyToken(ctx context.Context, c cache.Cache, token string) error {
+	return c.Delete(ctx, verifyTokenKey(token))
+}
+
+// Subscription is a webhook subscription. Strava only allows an application one subscription.
+type Subscription struct {
+	ID            int64     `json:"id"`
+	ApplicationID int64     `json:"application_id"`
+	CallbackURL   string    `json:"callback_url"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// StoredSubscription returns the subscription stored by EnsureSubscribed, or nil if there isn't one.
+func StoredSubscription(ctx context.Context, c cache.Cache) (*Subscription, error) {
+	ok, err := c.Exists(ctx, subscriptionKey)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var sub Subscription
+	if err := c.GetJSON(ctx, subscriptionKey, &sub); err != nil {
+		return nil, fmt.Errorf("getting stored subscription: %w", err)
+	}
+	return &sub, nil
+}
+
+// subscriptionClient returns a client for the push subscriptions API, which authenticates
+// with the application's client ID and secret rather than an athlete's token.
+func subscriptionClient() *client.Client {
+	return newClient(nil)
+}
+
+// credentials returns the application's client ID and secret as query parameters.
+func credentials() url.Values {
+	return url.Values{
+		"client_id":     {OauthConfig.ClientID},
+		"client_secret": {OauthConfig.ClientSecret},
+	}
+}
+
+// ListSubscriptions returns the application's webhook subscriptions.
+func ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	c := subscriptionClient()
+	req, err := c.NewRequest(ctx, http.MethodGet, subscriptionsPath+"?"+credentials().Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating list subscriptions request: %w", err)
+	}
+
+	var subs []Subscription
+	resp, err := c.Do(req, &subs)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+// CreateSubscription subscribes to webhook events sent to the callback URL. Strava
+// validates the callback URL by sending it the verify token before this returns.
+func CreateSubscription(ctx context.Context, callbackURL, verifyToken string) (*Subscription, error) {
+	c := subscriptionClient()
+	form := credentials()
+	form.Set("callback_url", callbackURL)
+	form.Set("verify_token", verifyToken)
+
+	u, err := c.BaseURL.Parse(subscriptionsPath)
+	if err != nil {
+		return nil, fmt.Errorf("creating subscription request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating subscription request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var sub Subscription
+	resp, err := c.Do(req, &sub)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating subscription: %w", err)
+	}
+	sub.CallbackURL = callbackURL
+	return &sub, nil
+}
+
+// DeleteSubscription deletes the webhook subscription, and the stored subscription if it's
+// the same one so webhook events aren't checked against a subscription that's gone.
+func DeleteSubscription(ctx context.Context, c cache.Cache, id int64) error {
+	sc := subscriptionClient()
+	req, err := sc.NewRequest(ctx, http.MethodDelete, fmt.Sprintf("%s/%d?%s", subscriptionsPath, id, credentials().Encode()), nil)
+	if err != nil {
+		return fmt.Errorf("creating delete subscription request: %w", err)
+	}
+
+	resp, err := sc.Do(req, nil)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("deleting subscription %d: %w", id, err)
+	}
+
+	stored, err := StoredSubscription(ctx, c)
+	if err != nil {
+		return err
+	}
+	if stored != nil && stored.ID == id {
+		if err := c.Delete(ctx, subscriptionKey); err != nil {
+			return fmt.Errorf("deleting stored subscription: %w", err)
+		}
+	}
+	return nil
+}
+
+// EnsureSubscribed makes sure the application is subscribed to webhook events sent to the
+// callback URL, returning the subscription and whether it had to be created. A subscription
+// for another callback URL is deleted first as Strava only allows one. New subscriptions
+// are verified with a generated token stored in the cache, which is discarded afterwards.
+// The subscription is stored in the cache so webhook events can be checked against it.
+func EnsureSubscribed(ctx context.Context, c cache.Cache, callbackURL string) (*Subscription, bool, error) {
+	// Don't delete the existing subscription only to fail creating a new one
+	if callbackURL == "" {
+		return nil, false, errors.New("missing callback URL")
+	}
+
+	subs, err := ListSubscriptions(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, sub := range subs {
+		if sub.CallbackURL == callbackURL {
+			return &sub, false, storeSubscription(ctx, c, &sub)
+		}
+	}
+	for _, sub := range subs {
+		slog.Info("deleting subscription with stale callback URL", "id", sub.ID, "callback_url", sub.CallbackURL) //nolint:gosec // G706 noise
+		if err := DeleteSubscription(ctx, c, sub.ID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	verifyToken, err := NewVerifyToken(ctx, c)
+	if err != nil {
+		return nil, false, err
+	}
+	// Strava has finished verifying the callback by the time it responds
+	defer func() {
+		if err := DiscardVerifyToken(context.WithoutCancel(ctx), c, verifyToken); err != nil {
+			slog.Error("unable to discard verify token", "error", err)
+		}
+	}()
+
+	sub, err := CreateSubscription(ctx, callbackURL, verifyToken)
+	if err != nil {
+		return nil, false, err
+	}
+	return sub, true, storeSubscription(ctx, c, sub)
+}
+
+func storeSubscription(ctx context.Context, c cache.Cache, sub *Subscription) error {
+	if err := c.SetJSON(ctx, subscriptionKey, sub); err != nil {
+		return fmt.Errorf("storing subscription: %w", err)
+	}
+	return nil
+}
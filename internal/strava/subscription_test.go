@@ -0,0 +1,217 @@
+package strava
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/lildude/strautomagically/internal/cache"
+)
+
+const subscriptionsURL = "https://www.strava.com/api/v3/push_subscriptions"
+
+func TestListSubscriptions(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	subs, _ := os.ReadFile("testdata/subscriptions.json")
+
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantIDs []int64
+		wantErr bool
+	}{
+		{"subscription exists", 200, string(subs), []int64{226199}, false},
+		{"no subscriptions", 200, `[]`, nil, false},
+		{"error", 401, `{"message":"Authorization Error"}`, nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			httpmock.RegisterResponder("GET", subscriptionsURL, httpmock.NewStringResponder(tc.status, tc.body))
+
+			got, err := ListSubscriptions(context.Background())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if len(got) != len(tc.wantIDs) {
+				t.Fatalf("expected %d subscriptions, got %d", len(tc.wantIDs), len(got))
+			}
+			for i, id := range tc.wantIDs {
+				if got[i].ID != id || got[i].CallbackURL != "https://example.com/webhook" || got[i].CreatedAt.IsZero() {
+					t.Errorf("expected subscription %d for https://example.com/webhook, got %+v", id, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCreateSubscription(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"created", 201, false},
+		// Strava rejects the subscription if the callback doesn't return the challenge
+		{"callback verification failed", 400, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var form map[string]string
+			httpmock.RegisterResponder("POST", subscriptionsURL, func(req *http.Request) (*http.Response, error) {
+				req.ParseForm()
+				form = map[string]string{"callback_url": req.PostForm.Get("callback_url"), "verify_token": req.PostForm.Get("verify_token")}
+				return httpmock.NewStringResponse(tc.status, `{"id":1}`), nil
+			})
+
+			sub, err := CreateSubscription(context.Background(), "https://example.com/webhook", "token")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if form["callback_url"] != "https://example.com/webhook" || form["verify_token"] != "token" {
+				t.Errorf("expected callback URL and verify token to be sent, got %v", form)
+			}
+			if !tc.wantErr && (sub.ID != 1 || sub.CallbackURL != "https://example.com/webhook") {
+				t.Errorf("expected subscription 1, got %+v", sub)
+			}
+		})
+	}
+}
+
+func TestDeleteSubscription(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("DELETE", subscriptionsURL+"/1", httpmock.NewStringResponder(204, ""))
+	httpmock.RegisterResponder("DELETE", subscriptionsURL+"/2", httpmock.NewStringResponder(404, `{"message":"Resource Not Found"}`))
+	httpmock.RegisterResponder("DELETE", subscriptionsURL+"/3", httpmock.NewStringResponder(204, ""))
+
+	ctx := context.Background()
+	c := cache.NewMemoryCache()
+	c.SetJSON(ctx, subscriptionKey, &Subscription{ID: 1})
+
+	if err := DeleteSubscription(ctx, c, 2); err == nil {
+		t.Error("expected error deleting a missing subscription")
+	}
+	if err := DeleteSubscription(ctx, c, 3); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if stored, _ := StoredSubscription(ctx, c); stored == nil {
+		t.Error("expected stored subscription to be kept when deleting another")
+	}
+	if err := DeleteSubscription(ctx, c, 1); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if stored, _ := StoredSubscription(ctx, c); stored != nil {
+		t.Errorf("expected stored subscription to be deleted, got %+v", stored)
+	}
+}
+
+func TestEnsureSubscribed(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	subs, _ := os.ReadFile("testdata/subscriptions.json")
+
+	tests := []struct {
+		name        string
+		callbackURL string
+		list        string
+		createCode  int
+		wantCreated bool
+		wantDeletes int
+		wantErr     bool
+	}{
+		{"already subscribed", "https://example.com/webhook", string(subs), 201, false, 0, false},
+		{"not subscribed", "https://example.com/webhook", `[]`, 201, true, 0, false},
+		{"stale callback URL replaced", "https://new.example.com/webhook", string(subs), 201, true, 1, false},
+		{"create fails", "https://example.com/webhook", `[]`, 400, false, 0, true},
+		{"missing callback URL", "", string(subs), 201, false, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := cache.NewMemoryCache()
+			httpmock.Reset()
+			httpmock.RegisterResponder("GET", subscriptionsURL, httpmock.NewStringResponder(200, tc.list))
+			httpmock.RegisterResponder("POST", subscriptionsURL, func(req *http.Request) (*http.Response, error) {
+				// Strava sends the verify token to the callback before responding
+				req.ParseForm()
+				if valid, _ := ValidVerifyToken(ctx, c, req.PostForm.Get("verify_token")); !valid {
+					t.Errorf("expected verify token %q to be valid", req.PostForm.Get("verify_token"))
+				}
+				return httpmock.NewStringResponse(tc.createCode, `{"id":2}`), nil
+			})
+			httpmock.RegisterResponder("DELETE", subscriptionsURL+"/226199", httpmock.NewStringResponder(204, ""))
+
+			sub, created, err := EnsureSubscribed(ctx, c, tc.callbackURL)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if keys, _ := c.Keys(ctx, "strava_verify_token:"); len(keys) != 0 {
+				t.Errorf("expected verify token to be discarded, got %v", keys)
+			}
+			if created != tc.wantCreated {
+				t.Errorf("expected created %v, got %v", tc.wantCreated, created)
+			}
+			if n := httpmock.GetCallCountInfo()["DELETE "+subscriptionsURL+"/226199"]; n != tc.wantDeletes {
+				t.Errorf("expected %d deletes, got %d", tc.wantDeletes, n)
+			}
+			if !tc.wantErr && sub.CallbackURL != tc.callbackURL {
+				t.Errorf("expected subscription for %s, got %+v", tc.callbackURL, sub)
+			}
+			stored, err := StoredSubscription(ctx, c)
+			if err != nil {
+				t.Fatalf("unexpected error getting stored subscription: %v", err)
+			}
+			if tc.wantErr != (stored == nil) || (stored != nil && stored.ID != sub.ID) {
+				t.Errorf("expected subscription to be stored, got %+v", stored)
+			}
+		})
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewMemoryCache()
+
+	token, err := NewVerifyToken(ctx, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, _ := NewVerifyToken(ctx, c)
+	if token == "" || token == other {
+		t.Errorf("expected unique random tokens, got %q and %q", token, other)
+	}
+
+	for _, tc := range []struct {
+		token string
+		want  bool
+	}{
+		{token, true},
+		{"", false},
+		{"wrong", false},
+	} {
+		if valid, err := ValidVerifyToken(ctx, c, tc.token); err != nil || valid != tc.want {
+			t.Errorf("ValidVerifyToken(%q) = %v, %v; want %v", tc.token, valid, err, tc.want)
+		}
+	}
+
+	if err := DiscardVerifyToken(ctx, c, token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if valid, _ := ValidVerifyToken(ctx, c, token); valid {
+		t.Error("expected discarded token to be invalid")
+	}
+}
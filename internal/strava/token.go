@@ -0,0 +1,144 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/secrets"
+	"golang.org/x/oauth2"
+)
+
+// tokenPrefix is the prefix of the cache keys holding athletes' tokens.
+const tokenPrefix = "strava_auth_token:"
+
+// ErrNoToken is returned when there's no token stored for the athlete.
+var ErrNoToken = errors.New("no token for athlete")
+
+// TokenKey returns the cache key for the athlete's OAuth token.
+func TokenKey(athleteID int64) string {
+	return tokenPrefix + strconv.FormatInt(athleteID, 10)
+}
+
+// SaveToken stores the athlete's token in the cache, encrypted with the first key in
+// TOKEN_ENCRYPTION_KEYS, or as plain JSON if it isn't set.
+func SaveToken(ctx context.Context, c cache.Cache, athleteID int64, token *oauth2.Token) error {
+	kr, err := secrets.FromEnv()
+	if err != nil {
+		return err
+	}
+	return saveToken(ctx, c, kr, TokenKey(athleteID), token)
+}
+
+func saveToken(ctx context.Context, c cache.Cache, kr *secrets.Keyring, key string, token *oauth2.Token) error {
+	if kr == nil {
+		return c.SetJSON(ctx, key, token)
+	}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("marshaling token: %w", err)
+	}
+	enc, err := kr.Encrypt(data, []byte(key))
+	if err != nil {
+		return fmt.Errorf("encrypting token: %w", err)
+	}
+	return c.Set(ctx, key, enc)
+}
+
+// LoadToken returns the athlete's token from the cache. Tokens stored as plain JSON or
+// encrypted with a key other than the first in TOKEN_ENCRYPTION_KEYS are encrypted with
+// the first key and stored again.
+func LoadToken(ctx context.Context, c cache.Cache, athleteID int64) (*oauth2.Token, error) {
+	kr, err := secrets.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	key := TokenKey(athleteID)
+	token, current, err := loadToken(ctx, c, kr, key)
+	if err != nil {
+		return nil, err
+	}
+	if !current {
+		if err := saveToken(ctx, c, kr, key, token); err != nil {
+			return nil, fmt.Errorf("encrypting stored token: %w", err)
+		}
+		slog.Info("encrypted stored token", "athlete_id", athleteID)
+	}
+	return token, nil
+}
+
+// loadToken returns the token stored at the key and whether it's stored the way saveToken
+// would store it now.
+func loadToken(ctx context.Context, c cache.Cache, kr *secrets.Keyring, key string) (*oauth2.Token, bool, error) {
+	v, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("getting token: %w", err)
+	}
+	s, _ := v.(string)
+	if s == "" {
+		return nil, false, ErrNoToken
+	}
+
+	data := []byte(s)
+	current := kr == nil
+	if secrets.Encrypted(s) {
+		if kr == nil {
+			return nil, false, errors.New("token is encrypted but TOKEN_ENCRYPTION_KEYS isn't set")
+		}
+		data, err = kr.Decrypt(s, []byte(key))
+		if err != nil {
+			return nil, false, fmt.Errorf("decrypting token: %w", err)
+		}
+		current = kr.Current(s)
+	}
+
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, false, fmt.Errorf("unmarshaling token: %w", err)
+	}
+	return token, current, nil
+}
+
+// EncryptTokens encrypts every stored token that's stored as plain JSON or encrypted with
+// an old key with the first key in TOKEN_ENCRYPTION_KEYS, returning how many were changed.
+// Run it after adding a new key so the old key can be removed.
+func EncryptTokens(ctx context.Context, c cache.Cache) (int, error) {
+	kr, err := secrets.FromEnv()
+	if err != nil {
+		return 0, err
+	}
+	if kr == nil {
+		return 0, errors.New("TOKEN_ENCRYPTION_KEYS isn't set")
+	}
+
+	keys, err := c.Keys(ctx, tokenPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("listing tokens: %w", err)
+	}
+
+	n := 0
+	for _, key := range keys {
+		token, current, err := loadToken(ctx, c, kr, key)
+		if errors.Is(err, ErrNoToken) {
+			continue
+		}
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", strings.TrimPrefix(key, tokenPrefix), err)
+		}
+		if current {
+			continue
+		}
+		if err := saveToken(ctx, c, kr, key, token); err != nil {
+			return n, fmt.Errorf("%s: %w", strings.TrimPrefix(key, tokenPrefix), err)
+		}
+		n++
+	}
+	return n, nil
+}
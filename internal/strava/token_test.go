@@ -0,0 +1,126 @@
+package strava
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/secrets"
+	"golang.org/x/oauth2"
+)
+
+func TestSaveLoadToken(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	ctx := context.Background()
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer"}
+
+	tests := []struct {
+		name string
+		// keys are the TOKEN_ENCRYPTION_KEYS used to save and then load the token
+		saveKeys, loadKeys string
+		wantErr            bool
+		// wantEncrypted is whether the stored token should be encrypted after loading it
+		wantEncrypted bool
+	}{
+		{"plaintext", "", "", false, false},
+		{"encrypted", newKey, newKey, false, true},
+		{"plaintext migrated", "", newKey, false, true},
+		{"rotated key migrated", oldKey, newKey + "," + oldKey, false, true},
+		{"encrypted without keys", newKey, "", true, true},
+		{"removed key", oldKey, newKey, true, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := cache.NewMemoryCache()
+			t.Setenv("TOKEN_ENCRYPTION_KEYS", tc.saveKeys)
+			if err := SaveToken(ctx, c, 1, token); err != nil {
+				t.Fatalf("unexpected error saving token: %v", err)
+			}
+			stored, _ := c.Get(ctx, TokenKey(1))
+			if tc.saveKeys != "" && strings.Contains(stored.(string), "refresh") {
+				t.Errorf("expected token to be encrypted, got %s", stored)
+			}
+
+			t.Setenv("TOKEN_ENCRYPTION_KEYS", tc.loadKeys)
+			got, err := LoadToken(ctx, c, 1)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+			if got.AccessToken != "access" || got.RefreshToken != "refresh" {
+				t.Errorf("expected token to be loaded, got %+v", got)
+			}
+
+			stored, _ = c.Get(ctx, TokenKey(1))
+			if secrets.Encrypted(stored.(string)) != tc.wantEncrypted {
+				t.Errorf("expected stored token to be encrypted %v, got %s", tc.wantEncrypted, stored)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := LoadToken(ctx, cache.NewMemoryCache(), 1); !errors.Is(err, ErrNoToken) {
+			t.Errorf("expected no token error, got %v", err)
+		}
+	})
+
+	t.Run("copied to another athlete", func(t *testing.T) {
+		c := cache.NewMemoryCache()
+		t.Setenv("TOKEN_ENCRYPTION_KEYS", newKey)
+		SaveToken(ctx, c, 1, token)
+		stored, _ := c.Get(ctx, TokenKey(1))
+		c.Set(ctx, TokenKey(2), stored)
+		if _, err := LoadToken(ctx, c, 2); err == nil {
+			t.Error("expected error loading a token copied from another athlete")
+		}
+	})
+}
+
+func TestEncryptTokens(t *testing.T) {
+	ctx := context.Background()
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}
+	c := cache.NewMemoryCache()
+
+	t.Setenv("TOKEN_ENCRYPTION_KEYS", "")
+	if _, err := EncryptTokens(ctx, c); err == nil {
+		t.Error("expected error encrypting tokens without keys")
+	}
+
+	SaveToken(ctx, c, 1, token)
+	t.Setenv("TOKEN_ENCRYPTION_KEYS", oldKey)
+	SaveToken(ctx, c, 2, token)
+	t.Setenv("TOKEN_ENCRYPTION_KEYS", newKey+","+oldKey)
+	SaveToken(ctx, c, 3, token)
+
+	n, err := EncryptTokens(ctx, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 tokens to be encrypted, got %d", n)
+	}
+
+	// Every token can be loaded with only the new key
+	t.Setenv("TOKEN_ENCRYPTION_KEYS", newKey)
+	for _, id := range []int64{1, 2, 3} {
+		if _, err := LoadToken(ctx, c, id); err != nil {
+			t.Errorf("athlete %d: unexpected error loading token: %v", id, err)
+		}
+	}
+	if n, _ := EncryptTokens(ctx, c); n != 0 {
+		t.Errorf("expected no tokens to need encrypting, got %d", n)
+	}
+}
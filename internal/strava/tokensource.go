@@ -0,0 +1,79 @@
+package strava
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/lildude/strautomagically/internal/cache"
+	"github.com/lildude/strautomagically/internal/client"
+	"golang.org/x/oauth2"
+)
+
+// refreshLocks holds a mutex per athlete so only one token source refreshes their
+// token at a time. Strava invalidates the old refresh token when it issues a new one
+// so concurrent refreshes could leave a token stored that can't be refreshed again.
+var refreshLocks sync.Map
+
+// TokenSource is an oauth2.TokenSource for an athlete's token stored in the cache.
+// Expired tokens are refreshed through OauthConfig and the new token is stored so
+// every token source for the athlete, in this process or another, picks it up.
+type TokenSource struct {
+	ctx       context.Context
+	cache     cache.Cache
+	athleteID int64
+
+	// token is the token last returned, guarded by the athlete's refresh lock.
+	token *oauth2.Token
+}
+
+// NewTokenSource returns a token source for the athlete's token in the cache. ctx is
+// used when refreshing the token.
+func NewTokenSource(ctx context.Context, c cache.Cache, athleteID int64) *TokenSource {
+	return &TokenSource{ctx: ctx, cache: c, athleteID: athleteID}
+}
+
+// Token returns a valid token for the athlete, refreshing and storing it if it has expired.
+func (ts *TokenSource) Token() (*oauth2.Token, error) {
+	mu, _ := refreshLocks.LoadOrStore(ts.athleteID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	if ts.token.Valid() {
+		return ts.token, nil
+	}
+
+	// Load the token again as it may have been refreshed since we last looked
+	token, err := LoadToken(ts.ctx, ts.cache, ts.athleteID)
+	if err != nil {
+		return nil, err
+	}
+	if token.Valid() {
+		ts.token = token
+		return token, nil
+	}
+
+	newToken, err := OauthConfig.TokenSource(ts.ctx, token).Token()
+	if err != nil {
+		return nil, fmt.Errorf("refreshing token: %w", err)
+	}
+	if err := SaveToken(ts.ctx, ts.cache, ts.athleteID, newToken); err != nil {
+		return nil, fmt.Errorf("storing token: %w", err)
+	}
+	slog.Info("updated token", "athlete_id", ts.athleteID)
+
+	ts.token = newToken
+	return newToken, nil
+}
+
+// NewClient returns a Strava API client authenticated as the athlete using their token in the cache.
+func NewClient(ctx context.Context, c cache.Cache, athleteID int64) (*client.Client, error) {
+	ts := NewTokenSource(ctx, c, athleteID)
+	// Get the token now so a missing or revoked token is reported here rather than on the first request
+	if _, err := ts.Token(); err != nil {
+		return nil, err
+	}
+
+	return newClient(oauth2.NewClient(ctx, ts)), nil
+}
@@ -0,0 +1,123 @@
+package strava
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/lildude/strautomagically/internal/cache"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenSource(t *testing.T) {
+	// Discard logs to avoid polluting test output
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	t.Setenv("TOKEN_ENCRYPTION_KEYS", "")
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	const refreshURL = "POST https://www.strava.com/oauth/token"
+	ctx := context.Background()
+	valid := &oauth2.Token{AccessToken: "valid", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	expired := &oauth2.Token{AccessToken: "expired", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}
+
+	tests := []struct {
+		name        string
+		stored      *oauth2.Token
+		status      int
+		wantAccess  string
+		wantRefresh int
+		wantErr     bool
+	}{
+		{"valid token", valid, 200, "valid", 0, false},
+		{"expired token is refreshed", expired, 200, "new", 1, false},
+		{"refresh fails", expired, 400, "", 1, true},
+		{"no token", nil, 200, "", 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			httpmock.Reset()
+			httpmock.RegisterResponder("POST", "https://www.strava.com/oauth/token",
+				httpmock.NewStringResponder(tc.status, `{"access_token":"new","refresh_token":"new-refresh","token_type":"Bearer","expires_in":21600}`))
+
+			c := cache.NewMemoryCache()
+			if tc.stored != nil {
+				SaveToken(ctx, c, 1, tc.stored)
+			}
+
+			ts := NewTokenSource(ctx, c, 1)
+			got, err := ts.Token()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if n := httpmock.GetCallCountInfo()[refreshURL]; n != tc.wantRefresh {
+				t.Errorf("expected %d refreshes, got %d", tc.wantRefresh, n)
+			}
+			if tc.wantErr {
+				return
+			}
+			if got.AccessToken != tc.wantAccess {
+				t.Errorf("expected access token %s, got %s", tc.wantAccess, got.AccessToken)
+			}
+
+			// The refreshed token is stored and reused
+			stored, err := LoadToken(ctx, c, 1)
+			if err != nil || stored.AccessToken != tc.wantAccess {
+				t.Errorf("expected stored access token %s, got %v, %v", tc.wantAccess, stored, err)
+			}
+			if _, err := ts.Token(); err != nil || httpmock.GetCallCountInfo()[refreshURL] != tc.wantRefresh {
+				t.Errorf("expected valid token to be reused without refreshing, got %v", err)
+			}
+		})
+	}
+
+	t.Run("concurrent refreshes", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("POST", "https://www.strava.com/oauth/token",
+			httpmock.NewStringResponder(200, `{"access_token":"new","refresh_token":"new-refresh","token_type":"Bearer","expires_in":21600}`))
+
+		c := cache.NewMemoryCache()
+		SaveToken(ctx, c, 2, expired)
+
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Go(func() {
+				if _, err := NewTokenSource(ctx, c, 2).Token(); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			})
+		}
+		wg.Wait()
+		if n := httpmock.GetCallCountInfo()[refreshURL]; n != 1 {
+			t.Errorf("expected the token to be refreshed once, got %d", n)
+		}
+	})
+}
+
+func TestNewClient(t *testing.T) {
+	t.Setenv("TOKEN_ENCRYPTION_KEYS", "")
+	ctx := context.Background()
+	c := cache.NewMemoryCache()
+
+	if _, err := NewClient(ctx, c, 1); !errors.Is(err, ErrNoToken) {
+		t.Errorf("expected no token error, got %v", err)
+	}
+
+	SaveToken(ctx, c, 1, &oauth2.Token{AccessToken: "valid", Expiry: time.Now().Add(time.Hour)})
+	sc, err := NewClient(ctx, c, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sc.BaseURL.String() != BaseURL {
+		t.Errorf("expected client for %s, got %s", BaseURL, sc.BaseURL)
+	}
+	// Strava's rate limits apply to the application so every client shares them
+	if sc.RateLimits != rateLimits || subscriptionClient().RateLimits != rateLimits {
+		t.Error("expected clients to share rate limits")
+	}
+}